@@ -2,34 +2,29 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"math"
 	"net/http"
-	"slices"
 	"sort"
 	"strconv"
-	"strings"
-	"time"
 
 	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/constitution"
 	"github.com/computersciencehouse/vote/database"
 	"github.com/computersciencehouse/vote/logging"
-	"github.com/computersciencehouse/vote/sse"
+	"github.com/computersciencehouse/vote/webhook"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Gets the number of people eligible to vote in a poll
 func GetVoterCount(poll database.Poll) int {
-	return len(poll.AllowedUsers)
+	return constitution.VoterCount(poll)
 }
 
 // Calculates the number of votes required for quorum in a poll
 func CalculateQuorum(poll database.Poll) int {
-	voterCount := GetVoterCount(poll)
-	return int(math.Ceil(float64(voterCount) * poll.QuorumType))
+	return constitution.Quorum(poll)
 }
 
 // GetHomepage Displays the main page of the application, containing a list of all currently open polls
@@ -47,11 +42,28 @@ func GetHomepage(c *gin.Context) {
 		return polls[i].Id > polls[j].Id
 	})
 
-	c.HTML(http.StatusOK, "index.tmpl", gin.H{
+	pollIds := make([]string, len(polls))
+	for i, poll := range polls {
+		pollIds[i] = poll.Id
+	}
+	// the badges are nice to have, so the list is still shown without them
+	numVotes, err := database.CountVoters(c, pollIds)
+	if err != nil {
+		logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "GetHomepage"}).Error(err)
+	}
+	voted, err := database.GetVotedPollIds(c, user.Username, pollIds)
+	if err != nil {
+		logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "GetHomepage"}).Error(err)
+	}
+
+	render(c, http.StatusOK, "index.tmpl", gin.H{
 		"Polls":    polls,
+		"NumVotes": numVotes,
+		"Voted":    voted,
 		"Username": user.Username,
 		"FullName": user.FullName,
 		"EBoard":   IsEboard(user),
+		"Evals":    IsEvals(user),
 	})
 }
 
@@ -59,29 +71,37 @@ func GetHomepage(c *gin.Context) {
 func GetClosedPolls(c *gin.Context) {
 	user := GetUserData(c)
 
-	closedPolls, err := database.GetClosedVotedPolls(c, user.Username)
+	closedPolls, err := getClosedPolls(c, user.Username)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	ownedPolls, err := database.GetClosedOwnedPolls(c, user.Username)
+
+	render(c, http.StatusOK, "closed.tmpl", gin.H{
+		"ClosedPolls": closedPolls,
+		"Username":    user.Username,
+		"FullName":    user.FullName,
+		"EBoard":      IsEboard(user),
+		"Evals":       IsEvals(user),
+	})
+}
+
+// getClosedPolls Retreives the closed polls a user either voted in or created, newest first
+func getClosedPolls(ctx context.Context, username string) ([]*database.Poll, error) {
+	closedPolls, err := database.GetClosedVotedPolls(ctx, username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, err
+	}
+	ownedPolls, err := database.GetClosedOwnedPolls(ctx, username)
+	if err != nil {
+		return nil, err
 	}
 	closedPolls = append(closedPolls, ownedPolls...)
 
 	sort.Slice(closedPolls, func(i, j int) bool {
 		return closedPolls[i].Id > closedPolls[j].Id
 	})
-	closedPolls = uniquePolls(closedPolls)
-
-	c.HTML(http.StatusOK, "closed.tmpl", gin.H{
-		"ClosedPolls": closedPolls,
-		"Username":    user.Username,
-		"FullName":    user.FullName,
-		"EBoard":      IsEboard(user),
-	})
+	return uniquePolls(closedPolls), nil
 }
 
 // GetPollById Retreives the information about a specific poll and displays it on the page, allowing the user to cast a ballot
@@ -99,7 +119,7 @@ func GetPollById(c *gin.Context) {
 	}
 
 	// If the user can't vote, just show them results
-	if canVote(user, *poll, poll.AllowedUsers) > 0 || !poll.Open {
+	if canVote(c, user, *poll) > 0 {
 		c.Redirect(http.StatusFound, "/results/"+poll.Id)
 		return
 	}
@@ -108,9 +128,7 @@ func GetPollById(c *gin.Context) {
 		writeInAdj = 1
 	}
 
-	canModify := IsActiveRTP(user) || IsEboard(user) || ownsPoll(poll, user)
-
-	c.HTML(200, "poll.tmpl", gin.H{
+	render(c, 200, "poll.tmpl", gin.H{
 		"Id":            poll.Id,
 		"Title":         poll.Title,
 		"Description":   poll.Description,
@@ -118,10 +136,12 @@ func GetPollById(c *gin.Context) {
 		"PollType":      poll.VoteType,
 		"RankedMax":     fmt.Sprint(len(poll.Options) + writeInAdj),
 		"AllowWriteIns": poll.AllowWriteIns,
-		"CanModify":     canModify,
+		"Audience":      poll.Audience,
+		"CanClose":      Can(user, ActionClose, poll),
 		"Username":      user.Username,
 		"FullName":      user.FullName,
 		"EBoard":        IsEboard(user),
+		"Evals":         IsEvals(user),
 	})
 }
 
@@ -129,68 +149,22 @@ func GetPollById(c *gin.Context) {
 func CreatePoll(c *gin.Context) {
 	user := GetUserData(c)
 
-	// If user is not active, display the unauthorized screen
-	if !IsActive(user) {
-		c.HTML(http.StatusForbidden, "unauthorized.tmpl", gin.H{
+	poll, err := newPoll(c, user, pollRequestFromForm(c))
+	var denied denial
+	if errors.As(err, &denied) {
+		render(c, http.StatusForbidden, "unauthorized.tmpl", gin.H{
+			"Reason":   err.Error(),
 			"Username": user.Username,
 			"FullName": user.FullName,
 			"EBoard":   IsEboard(user),
+			"Evals":    IsEvals(user),
 		})
 		return
 	}
-
-	quorumType := c.PostForm("quorumType")
-
-	quorum, err := strconv.ParseFloat(quorumType, 64)
-	quorum = quorum / 100
-
-	poll := &database.Poll{
-		Id:            "",
-		CreatedBy:     user.Username,
-		Title:         c.PostForm("title"),
-		Description:   c.PostForm("description"),
-		VoteType:      database.POLL_TYPE_SIMPLE,
-		OpenedTime:    time.Now(),
-		Open:          true,
-		QuorumType:    quorum,
-		Gatekeep:      c.PostForm("gatekeep") == "true",
-		AllowWriteIns: c.PostForm("allowWriteIn") == "true",
-		Hidden:        c.PostForm("hidden") == "true",
-	}
-	if c.PostForm("rankedChoice") == "true" {
-		poll.VoteType = database.POLL_TYPE_RANKED
-	}
-
-	switch c.PostForm("options") {
-	case "pass-fail-conditional":
-		poll.Options = []string{"Pass", "Fail/Conditional", "Abstain"}
-	case "fail-conditional":
-		poll.Options = []string{"Fail", "Conditional", "Abstain"}
-	case "custom":
-		poll.Options = []string{}
-		for opt := range strings.SplitSeq(c.PostForm("customOptions"), ",") {
-			poll.Options = append(poll.Options, strings.TrimSpace(opt))
-			if !slices.Contains(poll.Options, "Abstain") && (poll.VoteType == database.POLL_TYPE_SIMPLE) {
-				poll.Options = append(poll.Options, "Abstain")
-			}
-		}
-	case "pass-fail":
-	default:
-		poll.Options = []string{"Pass", "Fail", "Abstain"}
-	}
-	if poll.Gatekeep {
-		if !IsEboard(user) {
-			c.HTML(http.StatusForbidden, "unauthorized.tmpl", gin.H{
-				"Username": user.Username,
-				"FullName": user.FullName,
-				"EBoard":   IsEboard(user),
-			})
-			return
-		}
-		poll.AllowedUsers = GetEligibleVoters()
-		for user := range strings.SplitSeq(c.PostForm("waivedUsers"), ",") {
-			poll.AllowedUsers = append(poll.AllowedUsers, strings.TrimSpace(user))
-		}
+	if err != nil {
+		logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "CreatePoll"}).Error(err)
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
 	pollId, err := database.CreatePoll(c, poll)
@@ -198,6 +172,9 @@ func CreatePoll(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	poll.Id = pollId
+	emitWebhook(webhook.EventPollOpened, toAPIPoll(poll))
+	notifyPollChange(c, database.PollChange{PollId: pollId, Kind: database.PollCreated})
 
 	c.Redirect(http.StatusFound, "/poll/"+pollId)
 }
@@ -206,20 +183,12 @@ func CreatePoll(c *gin.Context) {
 func GetCreatePage(c *gin.Context) {
 	user := GetUserData(c)
 
-	// If the user is not active, display the unauthorized page
-	if !IsActive(user) {
-		c.HTML(http.StatusForbidden, "unauthorized.tmpl", gin.H{
-			"Username": user.Username,
-			"FullName": user.FullName,
-			"EBoard":   IsEboard(user),
-		})
-		return
-	}
-
-	c.HTML(http.StatusOK, "create.tmpl", gin.H{
+	render(c, http.StatusOK, "create.tmpl", gin.H{
 		"Username": user.Username,
 		"FullName": user.FullName,
 		"EBoard":   IsEboard(user),
+		"Evals":    IsEvals(user),
+		"Gatekeep": Can(user, ActionCreateGatekeep, nil),
 	})
 }
 
@@ -235,34 +204,45 @@ func GetPollResults(c *gin.Context) {
 		return
 	}
 
-	results, err := poll.GetResult(c)
+	results, err := poll.GetOfficialResult(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	// the same count as live updates, every ballot counts towards the first round
+	numVotes := toAPIResults(poll, results).NumVotes
 
-	canModify := IsActiveRTP(user) || IsEboard(user) || ownsPoll(poll, user)
-
-	if poll.Hidden && poll.Open {
-		c.HTML(http.StatusUnauthorized, "hidden.tmpl", gin.H{
+	if err := Authorize(user, ActionViewResults, poll); err != nil {
+		render(c, http.StatusUnauthorized, "hidden.tmpl", gin.H{
 			"Id":          poll.Id,
 			"Title":       poll.Title,
 			"Description": poll.Description,
+			"Audience":    poll.Audience,
+			"NumVotes":    numVotes,
 			"Username":    user.Username,
 			"FullName":    user.FullName,
 		})
 		return
 	}
 
-	numVotes := 0
+	var certified *database.CertifiedResult
+	if !poll.Open {
+		certified, err = poll.GetCertifiedResult(c)
+		if err != nil && !errors.Is(err, database.ErrNotCertified) {
+			logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "GetPollResults"}).Error(err)
+		}
+	}
 
-	for _, v := range results {
-		for key := range v {
-			numVotes += v[key]
+	userCanVote := canVote(c, user, *poll)
+	var appeal *database.Appeal
+	if userCanVote == 4 && poll.Open {
+		appeal, err = database.GetUserAppeal(c, poll.Id, user.Username)
+		if err != nil {
+			logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "GetPollResults"}).Error(err)
 		}
 	}
 
-	c.HTML(http.StatusOK, "result.tmpl", gin.H{
+	render(c, http.StatusOK, "result.tmpl", gin.H{
 		"Id":                   poll.Id,
 		"Title":                poll.Title,
 		"Description":          poll.Description,
@@ -271,14 +251,21 @@ func GetPollResults(c *gin.Context) {
 		"NumVotes":             numVotes,
 		"IsOpen":               poll.Open,
 		"IsHidden":             poll.Hidden,
-		"CanModify":            canModify,
-		"CanVote":              canVote(user, *poll, poll.AllowedUsers),
+		"CanHide":              Can(user, ActionHide, poll),
+		"CanClose":             Can(user, ActionClose, poll),
+		"CanExportBallots":     Can(user, ActionExportBallots, poll),
+		"CanVote":              userCanVote,
+		"Appeal":               appeal,
+		"Certified":            certified,
 		"Username":             user.Username,
 		"FullName":             user.FullName,
 		"EBoard":               IsEboard(user),
 		"Gatekeep":             poll.Gatekeep,
+		"Audience":             poll.Audience,
 		"Quorum":               strconv.FormatFloat(poll.QuorumType*100.0, 'f', 0, 64),
 		"EligibleVoters":       poll.AllowedUsers,
+		"Eligibility":          poll.EligibilityEntries(),
+		"Evals":                IsEvals(user),
 		"VotesNeededForQuorum": CalculateQuorum(*poll),
 	})
 }
@@ -293,36 +280,24 @@ func VoteInPoll(c *gin.Context) {
 		return
 	}
 
-	if canVote(user, *poll, poll.AllowedUsers) > 0 || !poll.Open {
+	if canVote(c, user, *poll) > 0 {
 		c.Redirect(http.StatusFound, "/results/"+poll.Id)
 		return
 	}
 
-	pId, err := primitive.ObjectIDFromHex(poll.Id)
+	ballot, err := ballotFromForm(c, poll)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 		return
 	}
-
-	if poll.VoteType == database.POLL_TYPE_SIMPLE {
-		processSimpleVote(c, poll, pId, user)
-	} else if poll.VoteType == database.POLL_TYPE_RANKED {
-		processRankedVote(c, poll, pId, user)
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unknown Poll Type"})
+	err = castBallot(c, user, poll, ballot)
+	if errors.Is(err, errAlreadyVoted) {
+		c.Redirect(http.StatusFound, "/results/"+poll.Id)
 		return
 	}
-
-	if poll, err := database.GetPoll(c, c.Param("id")); err == nil {
-		if results, err := poll.GetResult(c); err == nil {
-			if bytes, err := json.Marshal(results); err == nil {
-				broker.Notifier <- sse.NotificationEvent{
-					EventName: poll.Id,
-					Payload:   string(bytes),
-				}
-			}
-
-		}
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
 	}
 
 	c.Redirect(http.StatusFound, "/results/"+poll.Id)
@@ -334,32 +309,9 @@ func VoteInPoll(c *gin.Context) {
 //	a page informing the user that the results are hidden, instead of the actual results
 func HidePollResults(c *gin.Context) {
 	user := GetUserData(c)
+	poll := GetPollData(c)
 
-	poll, err := database.GetPoll(c, c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if !ownsPoll(poll, user) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only the creator can hide a poll result"})
-		return
-	}
-
-	err = poll.Hide(c)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	pId, _ := primitive.ObjectIDFromHex(poll.Id)
-	action := database.Action{
-		Id:     "",
-		PollId: pId,
-		Date:   primitive.NewDateTimeFromTime(time.Now()),
-		User:   user.Username,
-		Action: "Hide Results",
-	}
-	err = database.WriteAction(c, &action)
+	err := hidePoll(c, user, poll)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -371,41 +323,9 @@ func HidePollResults(c *gin.Context) {
 // ClosePoll Sets a poll to no longer allow votes to be cast
 func ClosePoll(c *gin.Context) {
 	user := GetUserData(c)
-	// This is intentionally left unprotected
-	// A user should be able to end their own polls, regardless of if they can vote
-
-	poll, err := database.GetPoll(c, c.Param("id"))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	if poll.Gatekeep {
-		c.JSON(http.StatusForbidden, gin.H{"error": "This poll cannot be closed manually"})
-		return
-	}
-
-	if !ownsPoll(poll, user) {
-		if !IsActiveRTP(user) && !IsEboard(user) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot end this poll."})
-			return
-		}
-	}
+	poll := GetPollData(c)
 
-	err = poll.Close(c)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	pId, _ := primitive.ObjectIDFromHex(poll.Id)
-	action := database.Action{
-		Id:     "",
-		PollId: pId,
-		Date:   primitive.NewDateTimeFromTime(time.Now()),
-		User:   user.Username,
-		Action: "Close/End Poll",
-	}
-	err = database.WriteAction(c, &action)
+	err := closePoll(c, user, poll)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -414,143 +334,36 @@ func ClosePoll(c *gin.Context) {
 	c.Redirect(http.StatusFound, "/results/"+poll.Id)
 }
 
-// ProcessSimpleVote Parses a simple ballot, validates it, and sends it to the database
-func processSimpleVote(c *gin.Context, poll *database.Poll, pId primitive.ObjectID, user cshAuth.CSHUserInfo) {
-	vote := database.SimpleVote{
-		Id:     "",
-		PollId: pId,
-		Option: c.PostForm("option"),
-	}
-	voter := database.Voter{
-		PollId: pId,
-		UserId: user.Username,
-	}
-
-	if hasOption(poll, c.PostForm("option")) {
-		vote.Option = c.PostForm("option")
-	} else if poll.AllowWriteIns && c.PostForm("option") == "writein" {
-		vote.Option = c.PostForm("writeinOption")
-	} else {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid Option"})
-		return
-	}
-	database.CastSimpleVote(c, &vote, &voter)
-}
-
-// ProcessRankedVote Parses the ranked choice ballot, validates it, and then sends it to the database
-func processRankedVote(c *gin.Context, poll *database.Poll, pId primitive.ObjectID, user cshAuth.CSHUserInfo) {
-	vote := database.RankedVote{
-		Id:      "",
-		PollId:  pId,
-		Options: make(map[string]int),
-	}
-	voter := database.Voter{
-		PollId: pId,
-		UserId: user.Username,
-	}
-
-	// Populate vote
-	for _, option := range poll.Options {
-		optionRankStr := c.PostForm(option)
-		optionRank, err := strconv.Atoi(optionRankStr)
-
-		if len(optionRankStr) < 1 {
-			continue
-		}
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "non-number ranking"})
-			return
-		}
-
-		vote.Options[option] = optionRank
-	}
-
-	// process write-in
-	if c.PostForm("writeinOption") != "" && c.PostForm("writein") != "" {
-		for candidate := range vote.Options {
-			if strings.EqualFold(candidate, strings.TrimSpace(c.PostForm("writeinOption"))) {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Write-in is already an option"})
-				return
-			}
-		}
-		rank, err := strconv.Atoi(c.PostForm("writein"))
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Write-in rank is not numerical"})
-			return
-		}
-		if rank < 1 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Write-in rank is not positive"})
-			return
-		}
-		vote.Options[c.PostForm("writeinOption")] = rank
-	}
-
-	validateRankedBallot(c, vote)
-
-	// Submit Vote
-	database.CastRankedVote(c, &vote, &voter)
-}
-
-// ValidateRankedBallot Verifies that the ranked choice ballot a user is attempting to submit is a valid ranked choice vote
-//
-// Specifically, it checks that the ballot is not empty, that there are no duplicate rankings, and that all rankings are between 1 and the total number of candidates
-func validateRankedBallot(c *gin.Context, vote database.RankedVote) {
-	// Perform checks, vote does not change beyond this
-	optionCount := len(vote.Options)
-	voted := make([]bool, optionCount)
-
-	// Make sure vote is not empty
-	if optionCount == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "You did not rank any options"})
-		return
-	}
-
-	// Duplicate ranks and range check
-	for _, rank := range vote.Options {
-		if rank > 0 && rank <= optionCount {
-			if rank > optionCount {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Rank choice is more than the amount of candidates ranked"})
-				return
-			}
-			if voted[rank-1] {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "You ranked two or more candidates at the same level"})
-				return
-			}
-			voted[rank-1] = true
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Candidates chosen must be from 1 to %d", optionCount)})
-			return
-		}
-	}
-}
-
 // canVote determines whether a user can cast a vote.
 //
 // Returns an integer value that indicates what the result is
 // 0 -> User is allowed to vote (success)
 // 1 -> Database error
+// 2 -> Poll is closed
 // 3 -> User is not active
 // 4 -> User doesnt meet gatekeep
+// 5 -> User is not in the poll's audience
 // 9 -> User has already voted
-func canVote(user cshAuth.CSHUserInfo, poll database.Poll, allowedUsers []string) int {
-	// always false if user is not active
-	if !DEV_DISABLE_ACTIVE_FILTERS && !IsActive(user) {
+func canVote(ctx context.Context, user cshAuth.CSHUserInfo, poll database.Poll) int {
+	err := checkVote(ctx, user, &poll)
+	var denied denial
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errAlreadyVoted):
+		return 9
+	case errors.Is(err, errNotActive):
 		return 3
-	}
-	voted, err := database.HasVoted(context.Background(), poll.Id, user.Username)
-	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{"method": "canVote"}).Error(err)
+	case errors.Is(err, errPollClosed):
+		return 2
+	case errors.Is(err, errNotInAudience):
+		return 5
+	case errors.As(err, &denied):
+		return 4
+	default:
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "canVote"}).Error(err)
 		return 1
 	}
-	if voted {
-		return 9
-	}
-	if poll.Gatekeep { //if gatekeep is enabled, but they aren't allowed to vote in the poll, false
-		if !slices.Contains(allowedUsers, user.Username) {
-			return 4
-		}
-	} //otherwise true
-	return 0
 }
 
 // ownsPoll Returns whether a user is the owner of a particular poll
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"time"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// APIError is the body of every error response from the JSON API
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// APIPoll is a poll as returned by the JSON API
+type APIPoll struct {
+	Id            string    `json:"id"`
+	CreatedBy     string    `json:"createdBy"`
+	Title         string    `json:"title"`
+	Description   string    `json:"description"`
+	VoteType      string    `json:"voteType"`
+	Options       []string  `json:"options"`
+	OpenedTime    time.Time `json:"openedTime"`
+	Open          bool      `json:"open"`
+	Hidden        bool      `json:"hidden"`
+	AllowWriteIns bool      `json:"allowWriteIns"`
+	Gatekeep      bool      `json:"gatekeep"`
+	QuorumPercent float64   `json:"quorumPercent,omitempty"`
+	Audience      []string  `json:"audience,omitempty"`
+	// Only set for polls limited to gatekeep or an audience
+	EligibleVoters int `json:"eligibleVoters,omitempty"`
+	// Only set when fetching a single poll
+	CanVote *bool `json:"canVote,omitempty"`
+}
+
+// APIResults are the results of a poll as returned by the JSON API
+type APIResults struct {
+	PollId               string `json:"pollId"`
+	VoteType             string `json:"voteType"`
+	Open                 bool   `json:"open"`
+	NumVotes             int    `json:"numVotes"`
+	EligibleVoters       int    `json:"eligibleVoters,omitempty"`
+	VotesNeededForQuorum int    `json:"votesNeededForQuorum,omitempty"`
+	// Simple polls have a single round, ranked polls have one per round of elimination
+	Rounds []map[string]int `json:"rounds"`
+}
+
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:          "invalid_request",
+	http.StatusUnauthorized:        "unauthenticated",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusConflict:            "conflict",
+	http.StatusBadGateway:          "upstream_unavailable",
+	http.StatusInternalServerError: "internal",
+}
+
+// RegisterAPIv1 Adds the JSON API routes under /api/v1
+func RegisterAPIv1(r *gin.Engine, auth Authenticator) {
+	v1 := r.Group("/api/v1", RequireAPIAuth(auth))
+
+	v1.GET("/polls", RequireScope(database.SCOPE_POLLS_READ), APIGetPolls)
+	v1.POST("/polls", RequireScope(database.SCOPE_POLLS_CREATE), RequireAPIPolicy(ActionCreate), APICreatePoll)
+	v1.GET("/polls/:id", RequireScope(database.SCOPE_POLLS_READ), APIGetPoll)
+	v1.POST("/polls/:id/ballots", RequireScope(database.SCOPE_POLLS_VOTE), APICastBallot)
+	v1.GET("/polls/:id/results", RequireScope(database.SCOPE_RESULTS_READ), RequireAPIPolicy(ActionViewResults), APIGetResults)
+	v1.POST("/polls/:id/close", RequireScope(database.SCOPE_POLLS_MANAGE), RequireAPIPolicy(ActionClose), APIClosePoll)
+	v1.POST("/polls/:id/hide", RequireScope(database.SCOPE_POLLS_MANAGE), RequireAPIPolicy(ActionHide), APIHidePoll)
+}
+
+// apiAbort Responds with a JSON API error
+func apiAbort(c *gin.Context, status int, message string) {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = "error"
+	}
+	c.AbortWithStatusJSON(status, gin.H{"error": APIError{Code: code, Message: message}})
+}
+
+// apiFail Responds with the JSON API error matching err
+func apiFail(c *gin.Context, err error) {
+	status := errorStatus(err)
+	if status >= http.StatusInternalServerError {
+		logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "apiFail", "path": c.FullPath()}).Error(err)
+	}
+	apiAbort(c, status, err.Error())
+}
+
+// RequireAPIAuth is middleware that rejects anyone who isn't logged in with a JSON error, rather than redirecting them
+//
+// Requests can also authenticate with an API token in the Authorization header
+func RequireAPIAuth(auth Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if secret, ok := bearerToken(c); ok {
+			if err := authenticateToken(c, secret); err != nil {
+				apiFail(c, err)
+				return
+			}
+			c.Set(logging.UsernameKey, GetUserData(c).Username)
+			c.Next()
+			return
+		}
+		if !auth.Authenticate(c) {
+			apiAbort(c, http.StatusUnauthorized, "You need to be logged in")
+			return
+		}
+		c.Set(logging.UsernameKey, GetUserData(c).Username)
+		// browsers send the session cookie with requests from any site, API tokens aren't sent automatically
+		if !checkCSRF(c) {
+			apiAbort(c, http.StatusForbidden, "Requests using a session cookie need the "+csrfHeader+" header")
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireAPIPolicy is RequirePolicy for the JSON API
+func RequireAPIPolicy(action Action) gin.HandlerFunc {
+	return requirePolicy(action, apiFail)
+}
+
+func toAPIPoll(poll *database.Poll) APIPoll {
+	apiPoll := APIPoll{
+		Id:            poll.Id,
+		CreatedBy:     poll.CreatedBy,
+		Title:         poll.Title,
+		Description:   poll.Description,
+		VoteType:      poll.VoteType,
+		Options:       poll.Options,
+		OpenedTime:    poll.OpenedTime,
+		Open:          poll.Open,
+		Hidden:        poll.Hidden,
+		AllowWriteIns: poll.AllowWriteIns,
+		Gatekeep:      poll.Gatekeep,
+		Audience:      poll.Audience,
+	}
+	if poll.Gatekeep {
+		apiPoll.QuorumPercent = poll.QuorumType * 100
+	}
+	if poll.Gatekeep || len(poll.Audience) > 0 {
+		apiPoll.EligibleVoters = GetVoterCount(*poll)
+	}
+	return apiPoll
+}
+
+// APIGetPolls Lists open polls, or with ?status=closed the closed polls the user voted in or created
+func APIGetPolls(c *gin.Context) {
+	user := GetUserData(c)
+
+	var polls []*database.Poll
+	var err error
+	switch c.DefaultQuery("status", "open") {
+	case "open":
+		polls, err = database.GetOpenPolls(c)
+		sort.Slice(polls, func(i, j int) bool {
+			return polls[i].Id > polls[j].Id
+		})
+	case "closed":
+		polls, err = getClosedPolls(c, user.Username)
+	default:
+		apiAbort(c, http.StatusBadRequest, "status must be open or closed")
+		return
+	}
+	if err != nil {
+		apiFail(c, err)
+		return
+	}
+
+	apiPolls := make([]APIPoll, 0, len(polls))
+	for _, poll := range polls {
+		apiPolls = append(apiPolls, toAPIPoll(poll))
+	}
+	c.JSON(http.StatusOK, gin.H{"polls": apiPolls})
+}
+
+// APIGetPoll Retreives a single poll, including whether the user can vote in it
+func APIGetPoll(c *gin.Context) {
+	user := GetUserData(c)
+
+	poll, err := loadPoll(c, c.Param("id"))
+	if err != nil {
+		apiFail(c, err)
+		return
+	}
+
+	apiPoll := toAPIPoll(poll)
+	userCanVote := canVote(c, user, *poll) == 0
+	apiPoll.CanVote = &userCanVote
+	c.JSON(http.StatusOK, apiPoll)
+}
+
+// APICreatePoll Creates a poll from a JSON PollRequest
+func APICreatePoll(c *gin.Context) {
+	user := GetUserData(c)
+
+	var req PollRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		apiAbort(c, http.StatusBadRequest, "Invalid poll: "+err.Error())
+		return
+	}
+
+	poll, err := newPoll(c, user, req)
+	if err != nil {
+		apiFail(c, err)
+		return
+	}
+	poll.Id, err = database.CreatePoll(c, poll)
+	if err != nil {
+		apiFail(c, err)
+		return
+	}
+	emitWebhook(webhook.EventPollOpened, toAPIPoll(poll))
+	notifyPollChange(c, database.PollChange{PollId: poll.Id, Kind: database.PollCreated})
+
+	c.JSON(http.StatusCreated, toAPIPoll(poll))
+}
+
+// APICastBallot Casts a JSON Ballot in a poll
+func APICastBallot(c *gin.Context) {
+	user := GetUserData(c)
+
+	// Tokens minted before impersonating ones were refused the polls:vote scope may still have it
+	if token, ok := c.Get(tokenKey); ok && token.(*database.APIToken).Impersonates() {
+		apiAbort(c, http.StatusForbidden, "Tokens acting as another user can't vote")
+		return
+	}
+
+	poll, err := loadPoll(c, c.Param("id"))
+	if err != nil {
+		apiFail(c, err)
+		return
+	}
+
+	var ballot Ballot
+	if err := c.ShouldBindJSON(&ballot); err != nil {
+		apiAbort(c, http.StatusBadRequest, "Invalid ballot: "+err.Error())
+		return
+	}
+
+	if err := castBallot(c, user, poll, ballot); err != nil {
+		apiFail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"pollId": poll.Id, "voter": user.Username})
+}
+
+// APIGetResults Retreives the current results of a poll
+func APIGetResults(c *gin.Context) {
+	poll := GetPollData(c)
+
+	results, err := poll.GetOfficialResult(c)
+	if err != nil {
+		apiFail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toAPIResults(poll, results))
+}
+
+func toAPIResults(poll *database.Poll, results []map[string]int) APIResults {
+	apiResults := APIResults{
+		PollId:   poll.Id,
+		VoteType: poll.VoteType,
+		Open:     poll.Open,
+		Rounds:   results,
+	}
+	// every ballot counts towards the first round
+	if len(results) > 0 {
+		for _, count := range results[0] {
+			apiResults.NumVotes += count
+		}
+	}
+	if poll.Gatekeep || len(poll.Audience) > 0 {
+		apiResults.EligibleVoters = GetVoterCount(*poll)
+	}
+	if poll.Gatekeep {
+		apiResults.VotesNeededForQuorum = CalculateQuorum(*poll)
+	}
+	return apiResults
+}
+
+// APIClosePoll Closes a poll
+func APIClosePoll(c *gin.Context) {
+	apiModifyPoll(c, closePoll)
+}
+
+// APIHidePoll Hides the results of a poll until it closes
+func APIHidePoll(c *gin.Context) {
+	apiModifyPoll(c, hidePoll)
+}
+
+func apiModifyPoll(c *gin.Context, modify func(ctx context.Context, user cshAuth.CSHUserInfo, poll *database.Poll) error) {
+	user := GetUserData(c)
+	poll := GetPollData(c)
+
+	if err := modify(c, user, poll); err != nil {
+		apiFail(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, toAPIPoll(poll))
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/computersciencehouse/vote/directory"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIErrors(t *testing.T) {
+	static, err := directory.LoadStatic("dev/directory.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	RegisterAPIv1(r, NewDevAuth(static))
+
+	tests := []struct {
+		name   string
+		user   string
+		noCSRF bool
+		bearer string
+		body   string
+		status int
+		error  string
+	}{
+		{
+			name:   "session without csrf token",
+			user:   "member",
+			noCSRF: true,
+			body:   `{"title": "Test"}`,
+			status: http.StatusForbidden,
+			error:  `{"error": {"code": "forbidden", "message": "Requests using a session cookie need the X-CSRF-Token header"}}`,
+		},
+		{
+			name:   "not logged in",
+			body:   `{"title": "Test"}`,
+			status: http.StatusUnauthorized,
+			error:  `{"error": {"code": "unauthenticated", "message": "You need to be logged in"}}`,
+		},
+		{
+			name:   "malformed token",
+			bearer: "not-a-vote-token",
+			body:   `{"title": "Test"}`,
+			status: http.StatusUnauthorized,
+			error:  `{"error": {"code": "unauthenticated", "message": "Invalid API token"}}`,
+		},
+		{
+			name:   "not active",
+			user:   "alumni",
+			body:   `{"title": "Test"}`,
+			status: http.StatusForbidden,
+			error:  `{"error": {"code": "forbidden", "message": "You need to be an active member to do that"}}`,
+		},
+		{
+			name:   "malformed",
+			user:   "member",
+			body:   `{"title": `,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "no title",
+			user:   "member",
+			body:   `{"title": " "}`,
+			status: http.StatusBadRequest,
+			error:  `{"error": {"code": "invalid_request", "message": "You need to give the poll a title"}}`,
+		},
+		{
+			name:   "gatekeep needs eboard",
+			user:   "member",
+			body:   `{"title": "Test", "gatekeep": true, "quorumPercent": 50}`,
+			status: http.StatusForbidden,
+			error:  `{"error": {"code": "forbidden", "message": "You need to be E-Board to do that"}}`,
+		},
+		{
+			name:   "gatekeep with audience",
+			user:   "eboard",
+			body:   `{"title": "Test", "gatekeep": true, "quorumPercent": 50, "audience": ["eboard"]}`,
+			status: http.StatusBadRequest,
+			error:  `{"error": {"code": "invalid_request", "message": "Gatekeep polls are already limited to eligible voters and can't also have an audience"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/polls", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", "application/json")
+			if test.bearer != "" {
+				req.Header.Set("Authorization", "Bearer "+test.bearer)
+			}
+			if test.user != "" {
+				req.AddCookie(&http.Cookie{Name: devAuthCookie, Value: test.user})
+				if !test.noCSRF {
+					addCSRF(req, test.user)
+				}
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, test.status, w.Code)
+			if test.error != "" {
+				assert.JSONEq(t, test.error, w.Body.String())
+			}
+		})
+	}
+}
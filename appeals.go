@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AppealView pairs a pending appeal with the poll it was filed on for display
+type AppealView struct {
+	Appeal *database.Appeal
+	Poll   *database.Poll
+}
+
+// appealStore is what resolving an appeal reads and changes
+type appealStore interface {
+	GetAppeal(ctx context.Context, id string) (*database.Appeal, error)
+	GetPoll(ctx context.Context, id string) (*database.Poll, error)
+	// Resolve returns database.ErrAppealResolved if the appeal isn't pending
+	Resolve(ctx context.Context, appeal *database.Appeal, status string, reviewer string) error
+	AddEligibility(ctx context.Context, poll *database.Poll, entry database.EligibilityEntry) (bool, error)
+	WriteAction(ctx context.Context, action *database.Action) error
+}
+
+// databaseAppeals resolves appeals in the database
+type databaseAppeals struct{}
+
+func (databaseAppeals) GetAppeal(ctx context.Context, id string) (*database.Appeal, error) {
+	return database.GetAppeal(ctx, id)
+}
+
+func (databaseAppeals) GetPoll(ctx context.Context, id string) (*database.Poll, error) {
+	return database.GetPoll(ctx, id)
+}
+
+func (databaseAppeals) Resolve(ctx context.Context, appeal *database.Appeal, status string, reviewer string) error {
+	return appeal.Resolve(ctx, status, reviewer)
+}
+
+func (databaseAppeals) AddEligibility(ctx context.Context, poll *database.Poll, entry database.EligibilityEntry) (bool, error) {
+	return poll.AddEligibility(ctx, entry)
+}
+
+func (databaseAppeals) WriteAction(ctx context.Context, action *database.Action) error {
+	return database.WriteAction(ctx, action)
+}
+
+var appealData appealStore = databaseAppeals{}
+
+// FileAppeal Records that a user believes they should be eligible to vote in a gatekeep poll
+func FileAppeal(c *gin.Context) {
+	user := GetUserData(c)
+
+	poll, err := database.GetPoll(c, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = checkVote(c, user, poll)
+	if !errors.Is(err, errNotEligible) {
+		if err != nil && errorStatus(err) == http.StatusInternalServerError {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You can only appeal eligibility for open polls you did not meet gatekeep for"})
+		return
+	}
+
+	existing, err := database.GetUserAppeal(c, poll.Id, user.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if existing != nil {
+		c.Redirect(http.StatusFound, "/results/"+poll.Id)
+		return
+	}
+
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	appeal := database.Appeal{
+		Id:        "",
+		PollId:    pId,
+		UserId:    user.Username,
+		Reason:    strings.TrimSpace(c.PostForm("reason")),
+		Status:    database.APPEAL_PENDING,
+		CreatedAt: time.Now(),
+	}
+	_, err = database.CreateAppeal(c, &appeal)
+	if errors.Is(err, database.ErrAppealExists) {
+		// submitted twice at once, the other request filed it
+		c.Redirect(http.StatusFound, "/results/"+poll.Id)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	action := database.Action{
+		Id:     "",
+		PollId: pId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: "File Eligibility Appeal",
+	}
+	err = database.WriteAction(c, &action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/results/"+poll.Id)
+}
+
+// GetAppeals Displays the pending eligibility appeals for Evals to review
+func GetAppeals(c *gin.Context) {
+	user := GetUserData(c)
+
+	appeals, err := database.GetPendingAppeals(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	views := make([]AppealView, 0, len(appeals))
+	for _, appeal := range appeals {
+		poll, err := database.GetPoll(c, appeal.PollId.Hex())
+		if err != nil {
+			logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "GetAppeals", "appeal": appeal.Id}).Error(err)
+			continue
+		}
+		views = append(views, AppealView{Appeal: appeal, Poll: poll})
+	}
+
+	render(c, http.StatusOK, "appeals.tmpl", gin.H{
+		"Appeals":  views,
+		"Username": user.Username,
+		"FullName": user.FullName,
+		"EBoard":   IsEboard(user),
+		"Evals":    IsEvals(user),
+	})
+}
+
+// ApproveAppeal Grants an appealing user eligibility in the poll they appealed and lets them know
+func ApproveAppeal(c *gin.Context) {
+	resolveAppeal(c, database.APPEAL_APPROVED)
+}
+
+// DenyAppeal Rejects an appeal and lets the user know
+func DenyAppeal(c *gin.Context) {
+	resolveAppeal(c, database.APPEAL_DENIED)
+}
+
+func resolveAppeal(c *gin.Context, status string) {
+	user := GetUserData(c)
+
+	appeal, err := appealData.GetAppeal(c, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	poll, err := appealData.GetPoll(c, appeal.PollId.Hex())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if status == database.APPEAL_APPROVED && !poll.Open {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This poll has closed, the appeal can only be denied"})
+		return
+	}
+
+	err = appealData.Resolve(c, appeal, status, user.Username)
+	if errors.Is(err, database.ErrAppealResolved) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This appeal has already been resolved"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	actionText := "Deny Eligibility Appeal: " + appeal.UserId
+	message := "Your appeal to vote in \"" + poll.Title + "\" was denied. Reach out to Evals if you have any questions."
+	if status == database.APPEAL_APPROVED {
+		_, err = appealData.AddEligibility(c, poll, database.EligibilityEntry{
+			Username: appeal.UserId,
+			Source:   database.ELIGIBILITY_APPEAL,
+			AddedBy:  user.Username,
+			AddedAt:  time.Now(),
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		actionText = "Approve Eligibility Appeal: " + appeal.UserId
+		message = "Your appeal to vote in \"" + poll.Title + "\" was approved! You can now vote at " + voteHost + "/poll/" + poll.Id
+	}
+
+	action := database.Action{
+		Id:     "",
+		PollId: appeal.PollId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: actionText,
+	}
+	err = appealData.WriteAction(c, &action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The decision is already recorded, so a failed DM shouldn't fail the request
+	if err = NotifyUser(c, appeal.UserId, message); err != nil {
+		logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "resolveAppeal notify"}).Error(err)
+	}
+
+	c.Redirect(http.StatusFound, "/appeals")
+}
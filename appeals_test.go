@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/directory"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeAppeals keeps a single appeal and its poll in memory, with the same conditions as the database
+type fakeAppeals struct {
+	appeal  *database.Appeal
+	poll    *database.Poll
+	actions []string
+}
+
+func (store *fakeAppeals) GetAppeal(ctx context.Context, id string) (*database.Appeal, error) {
+	appeal := *store.appeal
+	return &appeal, nil
+}
+
+func (store *fakeAppeals) GetPoll(ctx context.Context, id string) (*database.Poll, error) {
+	poll := *store.poll
+	return &poll, nil
+}
+
+func (store *fakeAppeals) Resolve(ctx context.Context, appeal *database.Appeal, status string, reviewer string) error {
+	if store.appeal.Status != database.APPEAL_PENDING {
+		return database.ErrAppealResolved
+	}
+	store.appeal.Status = status
+	store.appeal.ReviewedBy = reviewer
+	return nil
+}
+
+func (store *fakeAppeals) AddEligibility(ctx context.Context, poll *database.Poll, entry database.EligibilityEntry) (bool, error) {
+	if slices.Contains(store.poll.AllowedUsers, entry.Username) {
+		return false, nil
+	}
+	store.poll.Eligibility = append(store.poll.Eligibility, entry)
+	store.poll.AllowedUsers = append(store.poll.AllowedUsers, entry.Username)
+	return true, nil
+}
+
+func (store *fakeAppeals) WriteAction(ctx context.Context, action *database.Action) error {
+	store.actions = append(store.actions, action.Action)
+	return nil
+}
+
+func TestResolveAppeal(t *testing.T) {
+	static, err := directory.LoadStatic("dev/directory.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userDirectory = static
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	// denials are rendered as the unauthorized page
+	r.SetFuncMap(template.FuncMap{"inc": inc, "MakeLinks": MakeLinks})
+	r.LoadHTMLGlob("templates/*")
+	auth := RequireAuth(NewDevAuth(static))
+	r.POST("/appeals/:id/approve", auth, RequirePolicy(ActionReviewAppeals), ApproveAppeal)
+	r.POST("/appeals/:id/deny", auth, RequirePolicy(ActionReviewAppeals), DenyAppeal)
+
+	type request struct {
+		user   string
+		path   string
+		status int
+	}
+	tests := []struct {
+		name     string
+		open     bool
+		requests []request
+		appeal   string
+		eligible []database.EligibilityEntry
+		actions  []string
+	}{
+		{
+			name:     "member can't approve",
+			open:     true,
+			requests: []request{{user: "member", path: "approve", status: http.StatusForbidden}},
+			appeal:   database.APPEAL_PENDING,
+		},
+		{
+			name:     "eboard can't deny",
+			open:     true,
+			requests: []request{{user: "eboard", path: "deny", status: http.StatusForbidden}},
+			appeal:   database.APPEAL_PENDING,
+		},
+		{
+			name:     "evals approves",
+			open:     true,
+			requests: []request{{user: "evals", path: "approve", status: http.StatusFound}},
+			appeal:   database.APPEAL_APPROVED,
+			eligible: []database.EligibilityEntry{{Username: "ineligible", Source: database.ELIGIBILITY_APPEAL, AddedBy: "evals"}},
+			actions:  []string{"Approve Eligibility Appeal: ineligible"},
+		},
+		{
+			name:     "evals denies",
+			open:     true,
+			requests: []request{{user: "evals", path: "deny", status: http.StatusFound}},
+			appeal:   database.APPEAL_DENIED,
+			actions:  []string{"Deny Eligibility Appeal: ineligible"},
+		},
+		{
+			name: "approving twice is rejected",
+			open: true,
+			requests: []request{
+				{user: "evals", path: "approve", status: http.StatusFound},
+				{user: "evals", path: "approve", status: http.StatusConflict},
+			},
+			appeal:   database.APPEAL_APPROVED,
+			eligible: []database.EligibilityEntry{{Username: "ineligible", Source: database.ELIGIBILITY_APPEAL, AddedBy: "evals"}},
+			actions:  []string{"Approve Eligibility Appeal: ineligible"},
+		},
+		{
+			name: "denying after approval is rejected",
+			open: true,
+			requests: []request{
+				{user: "evals", path: "approve", status: http.StatusFound},
+				{user: "evals", path: "deny", status: http.StatusConflict},
+			},
+			appeal:   database.APPEAL_APPROVED,
+			eligible: []database.EligibilityEntry{{Username: "ineligible", Source: database.ELIGIBILITY_APPEAL, AddedBy: "evals"}},
+			actions:  []string{"Approve Eligibility Appeal: ineligible"},
+		},
+		{
+			name:     "closed polls can't be approved",
+			requests: []request{{user: "evals", path: "approve", status: http.StatusBadRequest}},
+			appeal:   database.APPEAL_PENDING,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pollId := primitive.NewObjectID()
+			store := &fakeAppeals{
+				appeal: &database.Appeal{Id: "appeal", PollId: pollId, UserId: "ineligible", Status: database.APPEAL_PENDING},
+				poll:   &database.Poll{Id: pollId.Hex(), Title: "Test", Open: test.open, Gatekeep: true, Eligibility: []database.EligibilityEntry{}, AllowedUsers: []string{}},
+			}
+			appealData = store
+			defer func() { appealData = databaseAppeals{} }()
+
+			for _, request := range test.requests {
+				req := httptest.NewRequest(http.MethodPost, "/appeals/appeal/"+request.path, nil)
+				req.AddCookie(&http.Cookie{Name: devAuthCookie, Value: request.user})
+				addCSRF(req, request.user)
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, req)
+				assert.Equal(t, request.status, w.Code)
+			}
+
+			assert.Equal(t, test.appeal, store.appeal.Status)
+			// AddedAt is set when the appeal is approved
+			for i := range store.poll.Eligibility {
+				store.poll.Eligibility[i].AddedAt = test.eligible[i].AddedAt
+			}
+			assert.Equal(t, len(test.eligible), len(store.poll.AllowedUsers))
+			if len(test.eligible) == 0 {
+				assert.Empty(t, store.poll.Eligibility)
+			} else {
+				assert.Equal(t, test.eligible, store.poll.Eligibility)
+			}
+			assert.Equal(t, test.actions, store.actions)
+		})
+	}
+}
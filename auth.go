@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt"
+)
+
+// Authenticator logs users in and identifies them on every request
+type Authenticator interface {
+	// Routes registers the login, callback and logout endpoints
+	Routes(r *gin.Engine)
+	// Authenticate identifies the user making a request, storing their claims for GetUserData
+	//
+	// It must not write a response, so the caller can decide how to handle someone who isn't logged in
+	Authenticate(c *gin.Context) bool
+}
+
+// RequireAuth is middleware that sends anyone who isn't logged in to the login page
+func RequireAuth(auth Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.Authenticate(c) {
+			c.Redirect(http.StatusFound, "/auth/login?referer="+url.QueryEscape(c.Request.URL.String()))
+			c.Abort()
+			return
+		}
+		c.Set(logging.UsernameKey, GetUserData(c).Username)
+		if !checkCSRF(c) {
+			csrfFailed(c)
+			return
+		}
+		c.Next()
+	}
+}
+
+// SSOAuth authenticates users against CSH SSO
+type SSOAuth struct {
+	csh *cshAuth.CSHAuth
+	// Secret the session cookies set by csh-auth are signed with
+	secret string
+}
+
+func NewSSOAuth(csh *cshAuth.CSHAuth, secret string) *SSOAuth {
+	return &SSOAuth{csh: csh, secret: secret}
+}
+
+func (auth *SSOAuth) Routes(r *gin.Engine) {
+	r.GET("/auth/login", auth.csh.AuthRequest)
+	r.GET("/auth/callback", auth.csh.AuthCallback)
+	r.GET("/auth/logout", auth.csh.AuthLogout)
+}
+
+// Authenticate checks the session cookie csh-auth sets after login, the same way its AuthWrapper does
+func (auth *SSOAuth) Authenticate(c *gin.Context) bool {
+	cookie, err := c.Cookie(cshAuth.CookieName)
+	if err != nil || cookie == "" {
+		return false
+	}
+	token, err := jwt.ParseWithClaims(cookie, &cshAuth.CSHClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("unexpected signing method")
+		}
+		return []byte(auth.secret), nil
+	})
+	if err != nil {
+		return false
+	}
+	claims, ok := token.Claims.(*cshAuth.CSHClaims)
+	if !ok || !token.Valid {
+		return false
+	}
+	c.Set(cshAuth.AuthKey, *claims)
+	return true
+}
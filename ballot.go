@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/metrics"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ballot is what a user submits when voting
+//
+// Simple polls use Option, or WriteIn if write-ins are allowed. Ranked polls use Ranks,
+// mapping each option to its rank, plus WriteIn and WriteInRank if write-ins are allowed
+type Ballot struct {
+	Option      string         `json:"option,omitempty"`
+	Ranks       map[string]int `json:"ranks,omitempty"`
+	WriteIn     string         `json:"writeIn,omitempty"`
+	WriteInRank int            `json:"writeInRank,omitempty"`
+}
+
+// ballotFromForm Parses the ballot submitted by the poll page
+func ballotFromForm(c *gin.Context, poll *database.Poll) (Ballot, error) {
+	ballot := Ballot{}
+	if poll.VoteType == database.POLL_TYPE_SIMPLE {
+		if c.PostForm("option") == "writein" {
+			ballot.WriteIn = c.PostForm("writeinOption")
+		} else {
+			ballot.Option = c.PostForm("option")
+		}
+		return ballot, nil
+	}
+
+	ballot.Ranks = make(map[string]int)
+	for _, option := range poll.Options {
+		optionRankStr := c.PostForm(option)
+		if len(optionRankStr) < 1 {
+			continue
+		}
+		optionRank, err := strconv.Atoi(optionRankStr)
+		if err != nil {
+			return ballot, userError("non-number ranking")
+		}
+		ballot.Ranks[option] = optionRank
+	}
+
+	if c.PostForm("writeinOption") != "" && c.PostForm("writein") != "" {
+		rank, err := strconv.Atoi(c.PostForm("writein"))
+		if err != nil {
+			return ballot, userError("Write-in rank is not numerical")
+		}
+		ballot.WriteIn = c.PostForm("writeinOption")
+		ballot.WriteInRank = rank
+	}
+	return ballot, nil
+}
+
+// checkVote Returns nil if the user may vote in a poll, errAlreadyVoted if they have, or the policy's reason if not.
+// Having voted is checked first so someone who voted and has since stopped being active is still told they voted
+func checkVote(ctx context.Context, user cshAuth.CSHUserInfo, poll *database.Poll) error {
+	voted, err := database.HasVoted(ctx, poll.Id, user.Username)
+	if err != nil {
+		return err
+	}
+	if voted {
+		return errAlreadyVoted
+	}
+	return Authorize(user, ActionVote, poll)
+}
+
+// castBallot Checks the user may vote, validates their ballot and sends it to the database
+func castBallot(ctx context.Context, user cshAuth.CSHUserInfo, poll *database.Poll, ballot Ballot) error {
+	if err := checkVote(ctx, user, poll); err != nil {
+		return err
+	}
+
+	pId, err := primitive.ObjectIDFromHex(poll.Id)
+	if err != nil {
+		return err
+	}
+	voter := database.Voter{
+		PollId: pId,
+		UserId: user.Username,
+	}
+
+	switch poll.VoteType {
+	case database.POLL_TYPE_SIMPLE:
+		vote, err := simpleVote(poll, pId, ballot)
+		if err != nil {
+			return err
+		}
+		err = database.CastSimpleVote(ctx, vote, &voter)
+		if errors.Is(err, database.ErrPollClosed) {
+			return errPollClosed
+		}
+		if err != nil {
+			return err
+		}
+	case database.POLL_TYPE_RANKED:
+		vote, err := rankedVote(poll, pId, ballot)
+		if err != nil {
+			return err
+		}
+		err = database.CastRankedVote(ctx, vote, &voter)
+		if errors.Is(err, database.ErrPollClosed) {
+			return errPollClosed
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return errors.New("Unknown Poll Type")
+	}
+	metrics.BallotsCast.WithLabelValues(poll.VoteType).Inc()
+
+	notifyPollChange(ctx, database.PollChange{PollId: poll.Id, Kind: database.BallotCast})
+	notifyPollChange(ctx, database.PollChange{PollId: poll.Id, Kind: database.VoterRecorded, Voter: user.Username})
+	emitQuorumReached(ctx, poll)
+	return nil
+}
+
+// simpleVote Validates a simple ballot
+func simpleVote(poll *database.Poll, pId primitive.ObjectID, ballot Ballot) (*database.SimpleVote, error) {
+	vote := database.SimpleVote{
+		Id:     "",
+		PollId: pId,
+	}
+
+	if ballot.Option != "" && hasOption(poll, ballot.Option) {
+		vote.Option = ballot.Option
+	} else if ballot.Option == "" && poll.AllowWriteIns && strings.TrimSpace(ballot.WriteIn) != "" {
+		vote.Option = strings.TrimSpace(ballot.WriteIn)
+	} else {
+		return nil, userError("Invalid Option")
+	}
+	return &vote, nil
+}
+
+// rankedVote Validates a ranked choice ballot
+func rankedVote(poll *database.Poll, pId primitive.ObjectID, ballot Ballot) (*database.RankedVote, error) {
+	vote := database.RankedVote{
+		Id:      "",
+		PollId:  pId,
+		Options: make(map[string]int),
+	}
+
+	for option, rank := range ballot.Ranks {
+		if !hasOption(poll, option) {
+			return nil, userError("Unknown option: " + option)
+		}
+		vote.Options[option] = rank
+	}
+
+	// process write-in
+	if strings.TrimSpace(ballot.WriteIn) != "" {
+		if !poll.AllowWriteIns {
+			return nil, userError("This poll does not allow write-ins")
+		}
+		writeIn := strings.TrimSpace(ballot.WriteIn)
+		if slices.ContainsFunc(poll.Options, func(option string) bool { return strings.EqualFold(option, writeIn) }) {
+			return nil, userError("Write-in is already an option")
+		}
+		if ballot.WriteInRank < 1 {
+			return nil, userError("Write-in rank is not positive")
+		}
+		vote.Options[writeIn] = ballot.WriteInRank
+	}
+
+	if err := validateRankedBallot(vote); err != nil {
+		return nil, err
+	}
+	return &vote, nil
+}
+
+// validateRankedBallot Verifies that the ranked choice ballot a user is attempting to submit is a valid ranked choice vote
+//
+// Specifically, it checks that the ballot is not empty, that there are no duplicate rankings, and that all rankings are between 1 and the total number of candidates
+func validateRankedBallot(vote database.RankedVote) error {
+	// Perform checks, vote does not change beyond this
+	optionCount := len(vote.Options)
+	voted := make([]bool, optionCount)
+
+	// Make sure vote is not empty
+	if optionCount == 0 {
+		return userError("You did not rank any options")
+	}
+
+	// Duplicate ranks and range check
+	for _, rank := range vote.Options {
+		if rank < 1 || rank > optionCount {
+			return userError(fmt.Sprintf("Candidates chosen must be from 1 to %d", optionCount))
+		}
+		if voted[rank-1] {
+			return userError("You ranked two or more candidates at the same level")
+		}
+		voted[rank-1] = true
+	}
+	return nil
+}
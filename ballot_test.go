@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSimpleVote(t *testing.T) {
+	poll := &database.Poll{Options: []string{"Pass", "Fail", "Abstain"}}
+	writeIns := &database.Poll{Options: []string{"Pass", "Fail", "Abstain"}, AllowWriteIns: true}
+
+	tests := []struct {
+		name   string
+		poll   *database.Poll
+		ballot Ballot
+		option string
+		err    error
+	}{
+		{name: "option", poll: poll, ballot: Ballot{Option: "Pass"}, option: "Pass"},
+		{name: "unknown option", poll: poll, ballot: Ballot{Option: "Maybe"}, err: userError("Invalid Option")},
+		{name: "empty", poll: poll, ballot: Ballot{}, err: userError("Invalid Option")},
+		{name: "write-in not allowed", poll: poll, ballot: Ballot{WriteIn: "Maybe"}, err: userError("Invalid Option")},
+		{name: "write-in", poll: writeIns, ballot: Ballot{WriteIn: " Maybe "}, option: "Maybe"},
+		{name: "blank write-in", poll: writeIns, ballot: Ballot{WriteIn: " "}, err: userError("Invalid Option")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vote, err := simpleVote(test.poll, primitive.NilObjectID, test.ballot)
+			assert.Equal(t, test.err, err)
+			if test.err == nil {
+				assert.Equal(t, test.option, vote.Option)
+			}
+		})
+	}
+}
+
+func TestRankedVote(t *testing.T) {
+	poll := &database.Poll{Options: []string{"Alice", "Bob", "Carol"}}
+	writeIns := &database.Poll{Options: []string{"Alice", "Bob", "Carol"}, AllowWriteIns: true}
+
+	tests := []struct {
+		name   string
+		poll   *database.Poll
+		ballot Ballot
+		ranks  map[string]int
+		err    error
+	}{
+		{
+			name:   "full ballot",
+			poll:   poll,
+			ballot: Ballot{Ranks: map[string]int{"Alice": 2, "Bob": 1, "Carol": 3}},
+			ranks:  map[string]int{"Alice": 2, "Bob": 1, "Carol": 3},
+		},
+		{
+			name:   "partial ballot",
+			poll:   poll,
+			ballot: Ballot{Ranks: map[string]int{"Carol": 1}},
+			ranks:  map[string]int{"Carol": 1},
+		},
+		{
+			name:   "empty ballot",
+			poll:   poll,
+			ballot: Ballot{},
+			err:    userError("You did not rank any options"),
+		},
+		{
+			name:   "duplicate ranks",
+			poll:   poll,
+			ballot: Ballot{Ranks: map[string]int{"Alice": 1, "Bob": 1}},
+			err:    userError("You ranked two or more candidates at the same level"),
+		},
+		{
+			name:   "rank out of range",
+			poll:   poll,
+			ballot: Ballot{Ranks: map[string]int{"Alice": 1, "Bob": 3}},
+			err:    userError("Candidates chosen must be from 1 to 2"),
+		},
+		{
+			name:   "unknown option",
+			poll:   poll,
+			ballot: Ballot{Ranks: map[string]int{"Dave": 1}},
+			err:    userError("Unknown option: Dave"),
+		},
+		{
+			name:   "write-in",
+			poll:   writeIns,
+			ballot: Ballot{Ranks: map[string]int{"Alice": 1}, WriteIn: "Dave", WriteInRank: 2},
+			ranks:  map[string]int{"Alice": 1, "Dave": 2},
+		},
+		{
+			name:   "write-in not allowed",
+			poll:   poll,
+			ballot: Ballot{Ranks: map[string]int{"Alice": 1}, WriteIn: "Dave", WriteInRank: 2},
+			err:    userError("This poll does not allow write-ins"),
+		},
+		{
+			name:   "write-in already an option",
+			poll:   writeIns,
+			ballot: Ballot{WriteIn: "alice", WriteInRank: 1},
+			err:    userError("Write-in is already an option"),
+		},
+		{
+			name:   "write-in without rank",
+			poll:   writeIns,
+			ballot: Ballot{WriteIn: "Dave"},
+			err:    userError("Write-in rank is not positive"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vote, err := rankedVote(test.poll, primitive.NilObjectID, test.ballot)
+			assert.Equal(t, test.err, err)
+			if test.err == nil {
+				assert.Equal(t, test.ranks, vote.Options)
+			}
+		})
+	}
+}
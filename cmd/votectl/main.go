@@ -0,0 +1,458 @@
+// votectl is a command line tool for operators to inspect and fix polls without the Mongo shell
+//
+// It connects to the same database as the site, using VOTE_MONGODB_URI or the mongo section of VOTE_CONFIG_FILE, and every command
+// that changes something is written to the action log under the operator's name
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/computersciencehouse/vote/config"
+	"github.com/computersciencehouse/vote/constitution"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const usage = `Usage: votectl [-operator name] <command> [arguments]
+
+Commands:
+  list [-status open|closed|all] [-limit n]   List polls, newest first
+  show <poll id>                              Show everything about a poll
+  close <poll id>                             Stop a poll accepting votes
+  reopen <poll id>                            Let a closed poll accept votes again
+  hide <poll id>                              Hide the results of a poll until it closes
+  recount <poll id>                           Count the votes in a poll again and check them against its certified result
+  certify <poll id>                           Certify the current result of a closed poll
+  actions [-poll id] [-limit n]               Show the action log, newest first
+  migrate [-dry-run]                          Run any migrations that haven't been run
+  evaluate                                    Show what the daily gatekeep evaluation would do, without doing it
+`
+
+var errUsage = errors.New("invalid usage")
+
+// cli holds what every command needs
+type cli struct {
+	out      io.Writer
+	operator string
+}
+
+type command func(ctx context.Context, cli *cli, args []string) error
+
+var commands = map[string]command{
+	"list":     listPolls,
+	"show":     showPoll,
+	"close":    closePoll,
+	"reopen":   reopenPoll,
+	"hide":     hidePoll,
+	"recount":  recountPoll,
+	"certify":  certifyPoll,
+	"actions":  listActions,
+	"migrate":  migrate,
+	"evaluate": evaluate,
+}
+
+func main() {
+	godotenv.Load()
+
+	err := run(context.Background(), os.Stdout, os.Args[1:], func() error {
+		cfg, err := config.Load()
+		if err != nil {
+			return err
+		}
+		if cfg.Mongo.URI == "" || cfg.Mongo.Database == "" {
+			return errors.New("VOTE_MONGODB_URI needs to be set to a URI that names a database")
+		}
+		database.Client = database.Connect(cfg.Mongo.URI, cfg.Mongo.Database)
+		return nil
+	})
+	if errors.Is(err, errUsage) {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "votectl:", err)
+		os.Exit(1)
+	}
+}
+
+// run Parses the arguments and runs a command, connecting to the database only once they're valid
+func run(ctx context.Context, out io.Writer, args []string, connect func() error) error {
+	flags := flag.NewFlagSet("votectl", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	operator := flags.String("operator", defaultOperator(), "name to record in the action log")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %s", errUsage, flags.Arg(0))
+	}
+	if *operator == "" {
+		return errors.New("unable to work out who you are, pass -operator")
+	}
+
+	if err := connect(); err != nil {
+		return err
+	}
+	return cmd(ctx, &cli{out: out, operator: *operator}, flags.Args()[1:])
+}
+
+func defaultOperator() string {
+	if name := os.Getenv("VOTE_OPERATOR"); name != "" {
+		return name
+	}
+	current, err := user.Current()
+	if err != nil {
+		return ""
+	}
+	return current.Username
+}
+
+// pollArg Loads the poll named by the only argument
+func pollArg(ctx context.Context, args []string) (*database.Poll, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%w: expected a poll id", errUsage)
+	}
+	poll, err := database.GetPoll(ctx, args[0])
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, fmt.Errorf("poll %s not found", args[0])
+	}
+	return poll, err
+}
+
+// record Writes an action taken by the operator to the action log
+func (cli *cli) record(ctx context.Context, poll *database.Poll, action string) error {
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	return database.WriteAction(ctx, &database.Action{
+		Id:     "",
+		PollId: pId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   cli.operator,
+		Action: "votectl: " + action,
+	})
+}
+
+func status(poll *database.Poll) string {
+	if poll.Open {
+		return "open"
+	}
+	return "closed"
+}
+
+func listPolls(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	pollStatus := flags.String("status", "all", "open, closed or all")
+	limit := flags.Int64("limit", 50, "number of polls to show, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+	filter := map[string]interface{}{}
+	switch *pollStatus {
+	case "open":
+		filter["open"] = true
+	case "closed":
+		filter["open"] = false
+	case "all":
+	default:
+		return fmt.Errorf("%w: status must be open, closed or all", errUsage)
+	}
+
+	polls, err := database.GetPolls(ctx, filter, *limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cli.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tTYPE\tOPENED\tCREATED BY\tTITLE")
+	for _, poll := range polls {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", poll.Id, status(poll), poll.VoteType, poll.OpenedTime.Format("2006-01-02 15:04"), poll.CreatedBy, poll.Title)
+	}
+	return w.Flush()
+}
+
+func showPoll(ctx context.Context, cli *cli, args []string) error {
+	poll, err := pollArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cli.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\t%s\n", poll.Id)
+	fmt.Fprintf(w, "Title\t%s\n", poll.Title)
+	fmt.Fprintf(w, "Description\t%s\n", poll.Description)
+	fmt.Fprintf(w, "Created By\t%s\n", poll.CreatedBy)
+	fmt.Fprintf(w, "Opened\t%s\n", poll.OpenedTime.Format(time.RFC3339))
+	fmt.Fprintf(w, "Status\t%s\n", status(poll))
+	fmt.Fprintf(w, "Type\t%s\n", poll.VoteType)
+	fmt.Fprintf(w, "Options\t%s\n", strings.Join(poll.Options, ", "))
+	fmt.Fprintf(w, "Write Ins\t%t\n", poll.AllowWriteIns)
+	fmt.Fprintf(w, "Hidden\t%t\n", poll.Hidden)
+	fmt.Fprintf(w, "Gatekeep\t%t\n", poll.Gatekeep)
+	if poll.Gatekeep {
+		fmt.Fprintf(w, "Quorum\t%.0f%% (%d votes)\n", poll.QuorumType*100, constitution.Quorum(*poll))
+	}
+	if len(poll.Audience) > 0 {
+		fmt.Fprintf(w, "Audience\t%s\n", strings.Join(poll.Audience, ", "))
+	}
+	if poll.AllowedUsers != nil {
+		fmt.Fprintf(w, "Eligible Voters\t%d\n", constitution.VoterCount(*poll))
+		sources := make(map[string]int)
+		for _, entry := range poll.EligibilityEntries() {
+			sources[entry.Source]++
+		}
+		for _, source := range sortedKeys(sources) {
+			fmt.Fprintf(w, "  %s\t%d\n", source, sources[source])
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if poll.Gatekeep && poll.AllowedUsers == nil {
+		fmt.Fprintln(cli.out, "\nWARNING: this gatekeep poll has no allowed users, so nobody can vote and it will never be evaluated")
+	}
+	return nil
+}
+
+func closePoll(ctx context.Context, cli *cli, args []string) error {
+	poll, err := pollArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	if !poll.Open {
+		return fmt.Errorf("poll %s is already closed", poll.Id)
+	}
+	if err := poll.Close(ctx); err != nil {
+		return err
+	}
+	if err := cli.record(ctx, poll, "Close/End Poll"); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Closed %s (%s)\n", poll.Id, poll.Title)
+	return certify(ctx, cli, poll)
+}
+
+func reopenPoll(ctx context.Context, cli *cli, args []string) error {
+	poll, err := pollArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	if poll.Open {
+		return fmt.Errorf("poll %s is already open", poll.Id)
+	}
+	if err := poll.Reopen(ctx); err != nil {
+		return err
+	}
+	if err := cli.record(ctx, poll, "Reopen Poll"); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Reopened %s (%s)\n", poll.Id, poll.Title)
+	return nil
+}
+
+func hidePoll(ctx context.Context, cli *cli, args []string) error {
+	poll, err := pollArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	if poll.Hidden {
+		return fmt.Errorf("poll %s is already hidden", poll.Id)
+	}
+	if err := poll.Hide(ctx); err != nil {
+		return err
+	}
+	if err := cli.record(ctx, poll, "Hide Results"); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Hid the results of %s (%s)\n", poll.Id, poll.Title)
+	return nil
+}
+
+func recountPoll(ctx context.Context, cli *cli, args []string) error {
+	poll, err := pollArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	recount, err := poll.Recount(ctx)
+	if errors.Is(err, database.ErrNotCertified) {
+		fmt.Fprintln(cli.out, "This poll has no certified result, so this count can't be checked")
+		results, err := poll.GetResult(ctx)
+		if err != nil {
+			return err
+		}
+		return printTally(cli.out, poll, results)
+	}
+	if err != nil {
+		return err
+	}
+
+	certified := recount.Certified
+	fmt.Fprintf(cli.out, "Certified %s by %s from %d ballots (hash %s, tally version %d)\n",
+		certified.CertifiedAt.Format(time.RFC3339), certified.CertifiedBy, certified.BallotCount, certified.BallotHash, certified.TallyVersion)
+	fmt.Fprintf(cli.out, "Recounted %d ballots (hash %s, tally version %d)\n\n", recount.BallotCount, recount.BallotHash, database.TALLY_VERSION)
+	if err := printTally(cli.out, poll, recount.Tally); err != nil {
+		return err
+	}
+	if len(recount.Mismatches) == 0 {
+		fmt.Fprintln(cli.out, "\nThe recount matches the certified result")
+		return nil
+	}
+	fmt.Fprintln(cli.out, "\nThe recount does not match the certified result:")
+	for _, mismatch := range recount.Mismatches {
+		fmt.Fprintln(cli.out, "  "+mismatch)
+	}
+	return errors.New("recount mismatch")
+}
+
+func certifyPoll(ctx context.Context, cli *cli, args []string) error {
+	poll, err := pollArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	if poll.Open {
+		return fmt.Errorf("poll %s is still open", poll.Id)
+	}
+	return certify(ctx, cli, poll)
+}
+
+// certify Freezes the current result of a poll as its official result
+func certify(ctx context.Context, cli *cli, poll *database.Poll) error {
+	certified, err := poll.Certify(ctx, cli.operator)
+	if err != nil {
+		return err
+	}
+	if err := cli.record(ctx, poll, "Certify Result "+certified.BallotHash); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Certified the result of %s from %d ballots (hash %s)\n", poll.Id, certified.BallotCount, certified.BallotHash)
+	return nil
+}
+
+func printTally(out io.Writer, poll *database.Poll, results []map[string]int) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	for i, round := range results {
+		if poll.VoteType == database.POLL_TYPE_RANKED {
+			fmt.Fprintf(w, "Round %d\n", i+1)
+		}
+		for _, option := range sortedKeys(round) {
+			fmt.Fprintf(w, "  %s\t%d\n", option, round[option])
+		}
+	}
+	return w.Flush()
+}
+
+func listActions(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("actions", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	pollId := flags.String("poll", "", "only show actions about this poll")
+	limit := flags.Int64("limit", 50, "number of actions to show, 0 for all")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	actions, err := database.GetActions(ctx, *pollId, *limit)
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(cli.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DATE\tPOLL\tUSER\tACTION")
+	for _, action := range actions {
+		poll := ""
+		if !action.PollId.IsZero() {
+			poll = action.PollId.Hex()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", action.Date.Time().Format("2006-01-02 15:04:05"), poll, action.User, action.Action)
+	}
+	return w.Flush()
+}
+
+func migrate(ctx context.Context, cli *cli, args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	dryRun := flags.Bool("dry-run", false, "only list the migrations that would run")
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	pending, err := database.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Fprintln(cli.out, "No migrations to run")
+		return nil
+	}
+	for _, migration := range pending {
+		if *dryRun {
+			fmt.Fprintf(cli.out, "Would run %s: %s\n", migration.Name, migration.Description)
+			continue
+		}
+		fmt.Fprintf(cli.out, "Running %s: %s\n", migration.Name, migration.Description)
+		if err := database.ApplyMigration(ctx, migration, cli.operator); err != nil {
+			return fmt.Errorf("migration %s failed: %w", migration.Name, err)
+		}
+		err := database.WriteAction(ctx, &database.Action{
+			Id:     "",
+			Date:   primitive.NewDateTimeFromTime(time.Now()),
+			User:   cli.operator,
+			Action: "votectl: Migrate " + migration.Name,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func evaluate(ctx context.Context, cli *cli, args []string) error {
+	polls, err := database.GetOpenGatekeepPolls(ctx)
+	if err != nil {
+		return err
+	}
+	if len(polls) == 0 {
+		fmt.Fprintln(cli.out, "No open gatekeep polls")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(cli.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tOPENED\tVOTED\tQUORUM\tDECISION\tTITLE")
+	for _, poll := range polls {
+		evaluation, err := constitution.Evaluate(ctx, poll, time.Now())
+		decision := evaluation.Decision.String()
+		if evaluation.Decision == constitution.Remind {
+			decision = fmt.Sprintf("remind %d", len(evaluation.NotVoted))
+		}
+		voted := fmt.Sprint(evaluation.Voted)
+		if err != nil {
+			decision = "error: " + err.Error()
+			voted = "?"
+		} else if evaluation.Decision == constitution.Wait && evaluation.Voted == 0 && len(evaluation.NotVoted) == 0 {
+			// polls less than a day old aren't counted
+			voted = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", poll.Id, poll.OpenedTime.Format("2006-01-02 15:04"), voted, evaluation.Quorum, decision, poll.Title)
+	}
+	return w.Flush()
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
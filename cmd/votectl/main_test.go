@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "no command", args: []string{}},
+		{name: "unknown command", args: []string{"delete"}},
+		{name: "unknown flag", args: []string{"-force", "list"}},
+		{name: "operator without command", args: []string{"-operator", "rtp"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			connected := false
+			err := run(context.Background(), io.Discard, test.args, func() error { connected = true; return nil })
+			assert.ErrorIs(t, err, errUsage)
+			assert.False(t, connected)
+		})
+	}
+}
+
+func TestRunOperator(t *testing.T) {
+	t.Setenv("VOTE_OPERATOR", "")
+	connected := false
+	err := run(context.Background(), io.Discard, []string{"-operator", "", "list"}, func() error { connected = true; return nil })
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, errUsage)
+	assert.False(t, connected)
+}
+
+func TestSortedKeys(t *testing.T) {
+	assert.Equal(t, []string{"gatekeep", "legacy", "waiver"}, sortedKeys(map[string]int{"waiver": 1, "gatekeep": 5, "legacy": 2}))
+}
@@ -0,0 +1,113 @@
+// Package constitution decides what should happen to open gatekeep polls
+//
+// Gatekeep polls follow the constitution: after a day, eligible voters who haven't voted are
+// reminded until quorum is met, and once quorum is met the poll closes after two days
+package constitution
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// Decision is what should happen to a poll when it is evaluated
+type Decision int
+
+const (
+	// Wait means there's nothing to do yet
+	Wait Decision = iota
+	// Remind means quorum hasn't been met and voters who haven't voted should be reminded
+	Remind
+	// Close means quorum has been met and the poll has been open long enough to close
+	Close
+)
+
+func (decision Decision) String() string {
+	switch decision {
+	case Wait:
+		return "wait"
+	case Remind:
+		return "remind"
+	case Close:
+		return "close"
+	default:
+		return "unknown"
+	}
+}
+
+// Evaluation is the state of a gatekeep poll and what should happen to it
+type Evaluation struct {
+	Poll     *database.Poll
+	Quorum   int
+	Voted    int
+	NotVoted []string
+	Decision Decision
+}
+
+// ErrNoAllowedUsers means a gatekeep poll doesn't know who can vote in it, which should never happen
+var ErrNoAllowedUsers = errors.New("users allowed to vote is nil")
+
+// VoterCount Gets the number of people eligible to vote in a poll
+func VoterCount(poll database.Poll) int {
+	return len(poll.AllowedUsers)
+}
+
+// Quorum Calculates the number of votes required for quorum in a poll
+func Quorum(poll database.Poll) int {
+	return int(math.Ceil(float64(VoterCount(poll)) * poll.QuorumType))
+}
+
+// Decide Works out what should happen to a poll given how many people have voted in it
+func Decide(poll database.Poll, voted int, now time.Time) Decision {
+	// if OpenedTime + 1 day later is before today, it's been open for less than 24 hours, and we will re-evaluate next run
+	// if after, it's been more than 24 hours
+	// we still won't close until 48, but we want to start messaging at 24
+	if poll.OpenedTime.AddDate(0, 0, 1).After(now) {
+		return Wait
+	}
+	if voted < Quorum(poll) {
+		return Remind
+	}
+	// close poll after 48 hours
+	if poll.OpenedTime.AddDate(0, 0, 2).After(now) {
+		return Wait
+	}
+	return Close
+}
+
+// Evaluate Checks who has voted in a poll and decides what should happen to it
+func Evaluate(ctx context.Context, poll *database.Poll, now time.Time) (Evaluation, error) {
+	evaluation := Evaluation{
+		Poll:     poll,
+		Quorum:   Quorum(*poll),
+		NotVoted: make([]string, 0),
+	}
+	if poll.OpenedTime.AddDate(0, 0, 1).After(now) {
+		evaluation.Decision = Wait
+		return evaluation, nil
+	}
+	if poll.AllowedUsers == nil {
+		return evaluation, ErrNoAllowedUsers
+	}
+
+	// check all voters to see if they have voted
+	for _, user := range poll.AllowedUsers {
+		voted, err := database.HasVoted(ctx, poll.Id, user)
+		if err != nil {
+			logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"module": "constitution", "method": "Evaluate hasVoted"}).Error(err)
+			continue
+		}
+		if voted {
+			evaluation.Voted++
+			continue
+		}
+		evaluation.NotVoted = append(evaluation.NotVoted, user)
+	}
+	evaluation.Decision = Decide(*poll, evaluation.Voted, now)
+	return evaluation, nil
+}
@@ -0,0 +1,42 @@
+package constitution
+
+import (
+	"testing"
+	"time"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuorum(t *testing.T) {
+	voters := []string{"a", "b", "c", "d", "e", "f", "g"}
+	assert.Equal(t, 4, Quorum(database.Poll{AllowedUsers: voters, QuorumType: 0.5}))
+	assert.Equal(t, 5, Quorum(database.Poll{AllowedUsers: voters, QuorumType: 0.66}))
+	assert.Equal(t, 7, Quorum(database.Poll{AllowedUsers: voters, QuorumType: 1}))
+	assert.Equal(t, 0, Quorum(database.Poll{QuorumType: 0.5}))
+}
+
+func TestDecide(t *testing.T) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	voters := []string{"a", "b", "c", "d"}
+
+	tests := []struct {
+		name     string
+		opened   time.Time
+		voted    int
+		decision Decision
+	}{
+		{name: "new poll without quorum", opened: now.Add(-time.Hour), voted: 0, decision: Wait},
+		{name: "new poll with quorum", opened: now.Add(-time.Hour), voted: 4, decision: Wait},
+		{name: "day old without quorum", opened: now.Add(-25 * time.Hour), voted: 1, decision: Remind},
+		{name: "day old with quorum", opened: now.Add(-25 * time.Hour), voted: 2, decision: Wait},
+		{name: "two days old without quorum", opened: now.Add(-49 * time.Hour), voted: 1, decision: Remind},
+		{name: "two days old with quorum", opened: now.Add(-49 * time.Hour), voted: 2, decision: Close},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			poll := database.Poll{OpenedTime: test.opened, AllowedUsers: voters, QuorumType: 0.5}
+			assert.Equal(t, test.decision, Decide(poll, test.voted, now))
+		})
+	}
+}
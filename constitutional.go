@@ -2,16 +2,15 @@ package main
 
 import (
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"os"
-	"strings"
+	"errors"
+	"sync/atomic"
 	"time"
 
+	"github.com/computersciencehouse/vote/config"
+	"github.com/computersciencehouse/vote/constitution"
 	"github.com/computersciencehouse/vote/database"
 	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/metrics"
 	"github.com/sirupsen/logrus"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/socketmode"
@@ -24,38 +23,32 @@ type SlackData struct {
 
 var slackData = SlackData{}
 
-func InitConstitution() {
-	slackData.AnnouncementsChannel = os.Getenv("VOTE_ANNOUNCEMENTS_CHANNEL_ID")
-	if slackData.AnnouncementsChannel == "" {
-		logging.Logger.WithFields(logrus.Fields{"method": "InitConstitution"}).Error("No announcements channel ID specified")
-	}
+// Closing this stops the daily poll evaluation
+var evaluatorQuit = make(chan struct{})
 
-	appToken := os.Getenv("VOTE_SLACK_APP_TOKEN")
-	if appToken == "" {
-		logging.Logger.WithFields(logrus.Fields{"method": "InitSlack"}).Error("No Slack app token specified")
-	}
-	if !strings.HasPrefix(appToken, "xapp-") {
-		logging.Logger.WithFields(logrus.Fields{"method": "InitConstitution"}).Error("Invalid Slack app token (should have prefix \"xapp-\".")
-	}
+// Closed once the daily poll evaluation has stopped, after any run in progress finishes
+var evaluatorStopped = make(chan struct{})
 
-	botToken := os.Getenv("VOTE_SLACK_BOT_TOKEN")
-	if botToken == "" {
-		logging.Logger.WithFields(logrus.Fields{"method": "InitConstitution"}).Error("No Slack bot token specified")
-	}
-	if !strings.HasPrefix(botToken, "xoxb-") {
-		logging.Logger.WithFields(logrus.Fields{"method": "InitConstitution"}).Error("Invalid Slack bot token (should have prefix \"xoxb-\".")
-	}
+// Whether the daily poll evaluation goroutine is running
+var evaluatorAlive atomic.Bool
 
-	api := slack.New(botToken, slack.OptionAppLevelToken(appToken))
+// InitConstitution Connects to Slack and schedules the daily evaluation of gatekeep polls
+//
+// The tokens are checked when the config is validated at startup
+func InitConstitution(config config.SlackConfig) {
+	slackData.AnnouncementsChannel = config.AnnouncementsChannel
+	api := slack.New(config.BotToken, slack.OptionAppLevelToken(config.AppToken))
 	slackData.Client = socketmode.New(api)
 	t := time.Now()
 	startOfDay := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
 	nextMidnight := startOfDay.AddDate(0, 0, 1)
-	fmt.Println(nextMidnight)
-	fmt.Println(time.Until(nextMidnight))
+	logging.Logger.WithFields(logrus.Fields{"method": "InitConstitution", "next": nextMidnight, "in": time.Until(nextMidnight).String()}).Info("scheduled poll evaluation")
 	ticker := time.NewTicker(time.Until(nextMidnight))
 	first := true
+	evaluatorAlive.Store(true)
 	go func() {
+		defer close(evaluatorStopped)
+		defer evaluatorAlive.Store(false)
 		for {
 			select {
 			case <-ticker.C:
@@ -64,7 +57,7 @@ func InitConstitution() {
 					ticker.Reset(24 * time.Hour)
 					first = false
 				}
-			case <-oidcClient.quit:
+			case <-evaluatorQuit:
 				ticker.Stop()
 				return
 			}
@@ -72,107 +65,91 @@ func InitConstitution() {
 	}()
 }
 
-// GetEligibleVoters returns a string slice of usernames of eligible voters
-func GetEligibleVoters() []string {
-	htclient := &http.Client{}
-	req, err := http.NewRequest("GET", CONDITIONAL_GATEKEEP_URL, nil)
-	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{"method": "GetUserGatekeep"}).Error(err)
-		return nil
-	}
-	req.Header.Add("X-VOTE-TOKEN", VOTE_TOKEN)
-	resp, err := htclient.Do(req)
+// StopConstitution Stops the daily poll evaluation, waiting for a run that's in progress to finish
+func StopConstitution() {
+	close(evaluatorQuit)
+	<-evaluatorStopped
+}
+
+// NotifyUser sends a Slack DM to a user, looking up their Slack ID in the directory
+func NotifyUser(ctx context.Context, username string, text string) error {
+	slackUID, err := userDirectory.GetSlackUID(ctx, username)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{"method": "GetUserGatekeep"}).Error(err)
-		return nil
+		return err
 	}
-	defer resp.Body.Close()
-	b, _ := io.ReadAll(resp.Body)
-	if strings.Contains(string(b), "Users") {
-		logging.Logger.WithFields(logrus.Fields{"method": "GetUserGatekeep"}).Error("Conditional Gatekeep token is incorrect")
-		return nil
+	if slackUID == "" {
+		return errors.New("user " + username + " does not have a SlackUID")
 	}
-	res := make([]string, 0)
-	err = json.Unmarshal(b, &res)
-	return res
+	_, _, err = slackData.Client.PostMessageContext(ctx, slackUID, slack.MsgOptionText(text, false))
+	return err
 }
 
 func EvaluatePolls() {
+	metrics.EvaluatorRuns.Inc()
 	ctx := context.Background()
 	polls, err := database.GetOpenGatekeepPolls(ctx)
 	if err != nil {
-		logging.Logger.WithFields(logrus.Fields{"method": "EvaluatePolls getOpen"}).Error(err)
+		metrics.EvaluatorErrors.WithLabelValues("get_open").Inc()
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "EvaluatePolls getOpen"}).Error(err)
 		return
 	}
 	for _, poll := range polls {
-		now := time.Now()
-		// if OpenedTime + 1 day later is before today, it's been open for less than 24 hours, and we will re-evaluate next run
-		// if after, it's been more than 24 hours
-		// we still won't close until 48, but we want to start messaging at 24
-		if poll.OpenedTime.AddDate(0, 0, 1).After(now) {
+		evaluation, err := constitution.Evaluate(ctx, poll, time.Now())
+		if errors.Is(err, constitution.ErrNoAllowedUsers) {
+			metrics.EvaluatorErrors.WithLabelValues("evaluate").Inc()
+			logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "EvaluatePolls checkQuorum"}).Error(
+				"Users allowed to vote is nil for \"" + poll.Title + "\" !! This should not happen!!")
 			continue
 		}
-
-		quorum := CalculateQuorum(*poll)
-
-		notVoted := make([]*OIDCUser, 0)
-		votedCount := 0
-		// check all voters to see if they have voted
-		if poll.AllowedUsers == nil {
-			logging.Logger.WithFields(logrus.Fields{"method": "EvaluatePolls checkQuorum"}).Error(
-				"Users allowed to vote is nil for \"" + poll.Title + "\" !! This should not happen!!")
+		if err != nil {
+			metrics.EvaluatorErrors.WithLabelValues("evaluate").Inc()
+			logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "EvaluatePolls evaluate"}).Error(err)
 			continue
 		}
-		for _, user := range poll.AllowedUsers {
-			voted, err := database.HasVoted(ctx, poll.Id, user)
-			if err != nil {
-				logging.Logger.WithFields(logrus.Fields{"method": "EvaluatePolls hasVoted"}).Error(err)
+		pollLink := voteHost + "/poll/" + poll.Id
+		switch evaluation.Decision {
+		case constitution.Remind:
+			for _, user := range evaluation.NotVoted {
+				err = NotifyUser(ctx, user,
+					"Hello, you have not yet voted on \""+poll.Title+"\". We have not yet hit quorum"+
+						" and we need YOU :index_pointing_at_the_viewer: to complete your responsibility as a "+
+						"member of house and vote. \n"+pollLink+"\nThank you!")
+				if err != nil {
+					metrics.EvaluatorErrors.WithLabelValues("remind").Inc()
+					logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "EvaluatePolls dm"}).Error(err)
+					continue
+				}
+				metrics.EvaluatorReminders.Inc()
+			}
+		case constitution.Close:
+			// we close the poll here
+			logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "EvaluatePolls close", "poll": poll.Id}).Info("Time reached, closing poll " + poll.Title)
+			err = poll.Close(ctx)
+			// someone closed it by hand since it was evaluated
+			if errors.Is(err, database.ErrPollClosed) {
 				continue
 			}
-			if voted {
-				votedCount = votedCount + 1
+			if err != nil {
+				metrics.EvaluatorErrors.WithLabelValues("close").Inc()
+				logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "EvaluatePolls close"}).Error(err)
 				continue
 			}
-			notVoted = append(notVoted, &OIDCUser{Username: user})
-		}
-		pollLink := VOTE_HOST + "/poll/" + poll.Id
-		// quorum not met
-		if votedCount < quorum {
-			for _, user := range notVoted {
-				oidcClient.GetUserInfo(user)
-				_, _, err = slackData.Client.PostMessage(user.SlackUID,
-					slack.MsgOptionText(
-						"Hello, you have not yet voted on \""+poll.Title+"\". We have not yet hit quorum"+
-							" and we need YOU :index_pointing_at_the_viewer: to complete your responsibility as a "+
-							"member of house and vote. \n"+pollLink+"\nThank you!", false))
-				if err != nil {
-					logging.Logger.WithFields(logrus.Fields{"method": "EvaluatePolls dm"}).Error(err)
-					continue
-				}
+			metrics.EvaluatorPollsClosed.Inc()
+			certifyResult(ctx, poll, "constitution")
+			emitPollClosed(ctx, poll)
+			notifyPollChange(ctx, database.PollChange{PollId: poll.Id, Kind: database.PollOpenChanged})
+			announceStr := "The vote \"" + poll.Title + "\" has closed."
+			if !poll.Hidden {
+				announceStr += " Check out the results at " + pollLink
+			} else {
+				announceStr += " Results will be posted shortly."
+			}
+			_, _, _, err = slackData.Client.SendMessage(slackData.AnnouncementsChannel,
+				slack.MsgOptionText(announceStr, false))
+			if err != nil {
+				metrics.EvaluatorErrors.WithLabelValues("announce").Inc()
+				logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "EvaluatePolls announce"}).Error(err)
 			}
-			continue
-		}
-		// close poll after 48 hours
-		if poll.OpenedTime.AddDate(0, 0, 2).After(now) {
-			continue
-		}
-		// we close the poll here
-		err = poll.Close(ctx)
-		fmt.Println("Time reached, closing poll " + poll.Title)
-		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{"method": "EvaluatePolls close"}).Error(err)
-			continue
-		}
-		announceStr := "The vote \"" + poll.Title + "\" has closed."
-		if !poll.Hidden {
-			announceStr += " Check out the results at " + pollLink
-		} else {
-			announceStr += " Results will be posted shortly."
-		}
-		_, _, _, err = slackData.Client.SendMessage(slackData.AnnouncementsChannel,
-			slack.MsgOptionText(announceStr, false))
-		if err != nil {
-			logging.Logger.WithFields(logrus.Fields{"method": "EvaluatePolls announce"}).Error(err)
 		}
 	}
 }
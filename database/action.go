@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type Action struct {
@@ -25,3 +27,31 @@ func WriteAction(ctx context.Context, action *Action) error {
 
 	return nil
 }
+
+// GetActions returns the most recent actions, only those about a poll if pollId isn't empty
+func GetActions(ctx context.Context, pollId string, limit int64) ([]*Action, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	filter := map[string]interface{}{}
+	if pollId != "" {
+		pId, err := primitive.ObjectIDFromHex(pollId)
+		if err != nil {
+			return nil, err
+		}
+		filter["pollId"] = pId
+	}
+	opts := options.Find().SetSort(map[string]interface{}{"date": -1}).SetLimit(limit)
+	cursor, err := Client.Database(db).Collection("actions").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var actions []*Action
+	err = cursor.All(ctx, &actions)
+	if err != nil {
+		return nil, err
+	}
+
+	return actions, nil
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Appeal struct {
+	Id         string             `bson:"_id,omitempty"`
+	PollId     primitive.ObjectID `bson:"pollId"`
+	UserId     string             `bson:"userId"`
+	Reason     string             `bson:"reason"`
+	Status     string             `bson:"status"`
+	CreatedAt  time.Time          `bson:"createdAt"`
+	ReviewedBy string             `bson:"reviewedBy,omitempty"`
+	ReviewedAt time.Time          `bson:"reviewedAt,omitempty"`
+}
+
+const APPEAL_PENDING = "pending"
+const APPEAL_APPROVED = "approved"
+const APPEAL_DENIED = "denied"
+
+var ErrAppealResolved = errors.New("appeal has already been resolved")
+var ErrAppealExists = errors.New("user has already appealed this poll")
+
+// CreateAppeal Files an appeal, returning ErrAppealExists if the user already has one on the poll
+func CreateAppeal(ctx context.Context, appeal *Appeal) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := Client.Database(db).Collection("appeals").InsertOne(ctx, appeal)
+	if mongo.IsDuplicateKeyError(err) {
+		return "", ErrAppealExists
+	}
+	if err != nil {
+		return "", err
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+func GetAppeal(ctx context.Context, id string) (*Appeal, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(id)
+	var appeal Appeal
+	if err := Client.Database(db).Collection("appeals").FindOne(ctx, map[string]interface{}{"_id": objId}).Decode(&appeal); err != nil {
+		return nil, err
+	}
+
+	return &appeal, nil
+}
+
+// GetUserAppeal returns the most recent appeal a user filed on a poll, or nil if they have not filed one
+func GetUserAppeal(ctx context.Context, pollId, userId string) (*Appeal, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pId, err := primitive.ObjectIDFromHex(pollId)
+	if err != nil {
+		return nil, err
+	}
+
+	var appeal Appeal
+	opts := options.FindOne().SetSort(map[string]interface{}{"createdAt": -1})
+	err = Client.Database(db).Collection("appeals").FindOne(ctx, map[string]interface{}{"pollId": pId, "userId": userId}, opts).Decode(&appeal)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &appeal, nil
+}
+
+func GetPendingAppeals(ctx context.Context) ([]*Appeal, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(map[string]interface{}{"createdAt": 1})
+	cursor, err := Client.Database(db).Collection("appeals").Find(ctx, map[string]interface{}{"status": APPEAL_PENDING}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var appeals []*Appeal
+	err = cursor.All(ctx, &appeals)
+	if err != nil {
+		return nil, err
+	}
+
+	return appeals, nil
+}
+
+// Resolve marks a pending appeal as approved or denied
+//
+// Returns ErrAppealResolved if someone else resolved the appeal first
+func (appeal *Appeal) Resolve(ctx context.Context, status string, reviewer string) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(appeal.Id)
+	now := time.Now()
+
+	result, err := Client.Database(db).Collection("appeals").UpdateOne(ctx,
+		map[string]interface{}{"_id": objId, "status": APPEAL_PENDING},
+		map[string]interface{}{"$set": map[string]interface{}{"status": status, "reviewedBy": reviewer, "reviewedAt": now}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrAppealResolved
+	}
+
+	appeal.Status = status
+	appeal.ReviewedBy = reviewer
+	appeal.ReviewedAt = now
+	return nil
+}
@@ -0,0 +1,245 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// TALLY_VERSION identifies the counting rules in GetResult, bump it whenever they change
+// so certified results can be told apart from ones counted by older rules
+const TALLY_VERSION = 1
+
+// CertifiedResult is the official result of a poll, frozen when it closed
+//
+// Certified results are never deleted. If a poll is reopened its certifications are voided,
+// and when it closes again it is certified again and the newest certification is the official one
+type CertifiedResult struct {
+	Id     string             `bson:"_id,omitempty"`
+	PollId primitive.ObjectID `bson:"pollId"`
+	Tally  []map[string]int   `bson:"tally"`
+	// Number of ballots counted, and a hash of them that doesn't depend on the order they're read in
+	BallotCount  int       `bson:"ballotCount"`
+	BallotHash   string    `bson:"ballotHash"`
+	TallyVersion int       `bson:"tallyVersion"`
+	CertifiedBy  string    `bson:"certifiedBy"`
+	CertifiedAt  time.Time `bson:"certifiedAt"`
+	Voided       bool      `bson:"voided,omitempty"`
+	VoidedAt     time.Time `bson:"voidedAt,omitempty"`
+}
+
+// Recount is the result of counting the ballots of a poll again and comparing it to its certified result
+type Recount struct {
+	Certified   *CertifiedResult
+	Tally       []map[string]int
+	BallotCount int
+	BallotHash  string
+	// Every way the recount differs from the certified result, empty if it matches
+	Mismatches []string
+}
+
+var ErrNotCertified = errors.New("poll has no certified result")
+
+// ballotHash hashes the ballots of a poll, along with how many there are
+//
+// Each ballot is encoded as JSON and the encodings are sorted, so the hash only changes if the ballots themselves do
+func (poll *Poll) ballotHash(ctx context.Context) (int, string, error) {
+	var encoded []string
+	switch poll.VoteType {
+	case POLL_TYPE_SIMPLE:
+		votes, err := GetSimpleVotes(ctx, poll.Id)
+		if err != nil {
+			return 0, "", err
+		}
+		for _, vote := range votes {
+			b, err := json.Marshal(vote.Option)
+			if err != nil {
+				return 0, "", err
+			}
+			encoded = append(encoded, string(b))
+		}
+	case POLL_TYPE_RANKED:
+		votes, err := GetRankedVotes(ctx, poll.Id)
+		if err != nil {
+			return 0, "", err
+		}
+		for _, vote := range votes {
+			b, err := json.Marshal(vote.Preferences())
+			if err != nil {
+				return 0, "", err
+			}
+			encoded = append(encoded, string(b))
+		}
+	default:
+		return 0, "", fmt.Errorf("unknown poll type %s", poll.VoteType)
+	}
+	sort.Strings(encoded)
+	sum := sha256.Sum256([]byte(strings.Join(encoded, "\n")))
+	return len(encoded), hex.EncodeToString(sum[:]), nil
+}
+
+// How long certifying waits for ballots that were let in before a poll closed to be saved
+const ballotSettleTimeout = 10 * time.Second
+
+// waitForBallots waits until every ballot let into a closed poll has been saved
+//
+// Ballots that fail to save are uncounted, so this only gives up after ballotSettleTimeout if one is stuck
+func (poll *Poll) waitForBallots(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, ballotSettleTimeout)
+	defer cancel()
+
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	for {
+		var accepted Poll
+		opts := options.FindOne().SetProjection(map[string]interface{}{"ballotsAccepted": 1})
+		if err := Client.Database(db).Collection("polls").FindOne(ctx, map[string]interface{}{"_id": pId}, opts).Decode(&accepted); err != nil {
+			return err
+		}
+		saved, err := Client.Database(db).Collection("votes").CountDocuments(ctx, map[string]interface{}{"pollId": pId})
+		if err != nil {
+			return err
+		}
+		if int(saved) >= accepted.BallotsAccepted {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("only %d of the %d ballots let in have been saved: %w", saved, accepted.BallotsAccepted, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Certify counts the ballots of a poll and stores the result as its official result
+//
+// The poll should already be closed, any ballots let in before it closed are waited for so they're counted,
+// and nothing is certified if they aren't all saved in time
+func (poll *Poll) Certify(ctx context.Context, certifiedBy string) (*CertifiedResult, error) {
+	if err := poll.waitForBallots(ctx); err != nil {
+		return nil, err
+	}
+	tally, err := poll.GetResult(ctx)
+	if err != nil {
+		return nil, err
+	}
+	count, hash, err := poll.ballotHash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	result := CertifiedResult{
+		PollId:       pId,
+		Tally:        tally,
+		BallotCount:  count,
+		BallotHash:   hash,
+		TallyVersion: TALLY_VERSION,
+		CertifiedBy:  certifiedBy,
+		CertifiedAt:  time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	inserted, err := Client.Database(db).Collection("certified_results").InsertOne(ctx, result)
+	if err != nil {
+		return nil, err
+	}
+	result.Id = inserted.InsertedID.(primitive.ObjectID).Hex()
+	return &result, nil
+}
+
+// GetCertifiedResult returns the newest certified result of a poll that hasn't been voided, or ErrNotCertified
+func (poll *Poll) GetCertifiedResult(ctx context.Context) (*CertifiedResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	var result CertifiedResult
+	opts := options.FindOne().SetSort(map[string]interface{}{"certifiedAt": -1})
+	err := Client.Database(db).Collection("certified_results").FindOne(ctx, map[string]interface{}{"pollId": pId, "voided": map[string]interface{}{"$ne": true}}, opts).Decode(&result)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrNotCertified
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetOfficialResult returns the certified result of a closed poll, so later changes to
+// how votes are counted can't change it, or counts the votes if it hasn't been certified
+func (poll *Poll) GetOfficialResult(ctx context.Context) ([]map[string]int, error) {
+	if !poll.Open {
+		certified, err := poll.GetCertifiedResult(ctx)
+		if err == nil {
+			return certified.Tally, nil
+		}
+		if !errors.Is(err, ErrNotCertified) {
+			return nil, err
+		}
+	}
+	return poll.GetResult(ctx)
+}
+
+// Recount counts the ballots of a poll again and reports how it differs from its certified result
+func (poll *Poll) Recount(ctx context.Context) (*Recount, error) {
+	certified, err := poll.GetCertifiedResult(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tally, err := poll.GetResult(ctx)
+	if err != nil {
+		return nil, err
+	}
+	count, hash, err := poll.ballotHash(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recount := Recount{
+		Certified:   certified,
+		Tally:       tally,
+		BallotCount: count,
+		BallotHash:  hash,
+		Mismatches:  compareRecount(certified, tally, count, hash),
+	}
+	return &recount, nil
+}
+
+func compareRecount(certified *CertifiedResult, tally []map[string]int, count int, hash string) []string {
+	mismatches := make([]string, 0)
+	if count != certified.BallotCount {
+		mismatches = append(mismatches, fmt.Sprintf("ballot count changed from %d to %d", certified.BallotCount, count))
+	}
+	if hash != certified.BallotHash {
+		mismatches = append(mismatches, fmt.Sprintf("ballots changed, hash was %s and is now %s", certified.BallotHash, hash))
+	}
+	if len(tally) != len(certified.Tally) {
+		mismatches = append(mismatches, fmt.Sprintf("number of rounds changed from %d to %d", len(certified.Tally), len(tally)))
+	}
+	for i := 0; i < len(tally) && i < len(certified.Tally); i++ {
+		// an empty round decodes as nil
+		if len(tally[i]) == 0 && len(certified.Tally[i]) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(tally[i], certified.Tally[i]) {
+			mismatches = append(mismatches, fmt.Sprintf("round %d was %v and is now %v", i+1, certified.Tally[i], tally[i]))
+		}
+	}
+	if len(mismatches) > 0 && certified.TallyVersion != TALLY_VERSION {
+		mismatches = append(mismatches, fmt.Sprintf("certified with tally version %d, recounted with version %d", certified.TallyVersion, TALLY_VERSION))
+	}
+	return mismatches
+}
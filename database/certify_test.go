@@ -0,0 +1,41 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareRecount(t *testing.T) {
+	certified := &CertifiedResult{
+		Tally:        []map[string]int{{"Alice": 2, "Bob": 1, "Carol": 1}, {"Alice": 3, "Bob": 1}, {"Alice": 3}},
+		BallotCount:  4,
+		BallotHash:   "abc",
+		TallyVersion: TALLY_VERSION,
+	}
+
+	tests := []struct {
+		name       string
+		tally      []map[string]int
+		count      int
+		hash       string
+		mismatches int
+	}{
+		{name: "matches", tally: certified.Tally, count: 4, hash: "abc", mismatches: 0},
+		{name: "ballot added", tally: []map[string]int{{"Alice": 2, "Bob": 2, "Carol": 1}, {"Alice": 3, "Bob": 2}, {"Alice": 3}}, count: 5, hash: "def", mismatches: 4},
+		{name: "tally changed", tally: []map[string]int{{"Alice": 2, "Bob": 1, "Carol": 1}, {"Alice": 2, "Bob": 2}}, count: 4, hash: "abc", mismatches: 2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Len(t, compareRecount(certified, test.tally, test.count, test.hash), test.mismatches)
+		})
+	}
+
+	old := *certified
+	old.TallyVersion = TALLY_VERSION - 1
+	mismatches := compareRecount(&old, []map[string]int{{"Alice": 2, "Bob": 1, "Carol": 1}, {"Alice": 2, "Bob": 2}}, 4, "abc")
+	assert.Contains(t, mismatches[len(mismatches)-1], "tally version")
+
+	empty := &CertifiedResult{Tally: []map[string]int{nil}}
+	assert.Empty(t, compareRecount(empty, []map[string]int{{}}, 0, ""))
+}
@@ -2,12 +2,10 @@ package database
 
 import (
 	"context"
-	"os"
-	"strings"
-	"testing"
 	"time"
 
 	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/metrics"
 	"github.com/sirupsen/logrus"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
@@ -24,20 +22,14 @@ const (
 var Client *mongo.Client
 var db = ""
 
-func Connect() *mongo.Client {
-	// This always gets invoked on initialisation. bad! it'd be nice if we only did this setup in main rather than components under test. for now we just skip if testing
-	if testing.Testing() {
-		logging.Logger.WithFields(logrus.Fields{"module": "database", "method": "Connect"}).Info("testing, not doing db connection, someone should mock this someday")
-		return nil
-	}
-
+// Connect connects to MongoDB, exiting if it can't be reached, and uses database for everything stored
+func Connect(uri string, database string) *mongo.Client {
 	logging.Logger.WithFields(logrus.Fields{"module": "database", "method": "Connect"}).Info("beginning database connection")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	uri := os.Getenv("VOTE_MONGODB_URI")
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetMonitor(metrics.MongoMonitor()))
 
 	if err != nil {
 		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "database", "method": "Connect"}).Fatal("error connecting to database")
@@ -48,7 +40,7 @@ func Connect() *mongo.Client {
 	}
 
 	logging.Logger.WithFields(logrus.Fields{"module": "database", "method": "Connect"}).Info("connected to mongodb")
-	db = strings.Split(strings.Split(uri, "/")[3], "?")[0]
+	db = database
 
 	return client
 }
@@ -58,8 +50,16 @@ func Disconnect() {
 	defer cancel()
 
 	if err := Client.Disconnect(ctx); err != nil {
-		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "database", "method": "Disconnect"}).Fatal("error disconnecting from database")
+		logging.Logger.WithFields(logrus.Fields{"error": err, "module": "database", "method": "Disconnect"}).Error("error disconnecting from database")
 	}
 
 	logging.Logger.WithFields(logrus.Fields{"module": "database", "method": "Disconnect"}).Info("disconnected from database")
 }
+
+// Ping checks the primary can be reached
+func Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return Client.Ping(ctx, readpref.Primary())
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Migration is a one off change to the data in the database
+//
+// Migrations are run in order and each only runs once, so they must never be edited or reordered once released
+type Migration struct {
+	Name        string
+	Description string
+	Run         func(ctx context.Context) error
+}
+
+// AppliedMigration records that a migration has been run
+type AppliedMigration struct {
+	Name      string    `bson:"_id"`
+	AppliedAt time.Time `bson:"appliedAt"`
+	AppliedBy string    `bson:"appliedBy"`
+}
+
+var Migrations = []Migration{
+	{
+		Name:        "backfill-eligibility",
+		Description: "Record legacy eligibility for polls created before eligibility sources were tracked",
+		Run:         backfillEligibility,
+	},
+	{
+		Name:        "create-indexes",
+		Description: "Index the fields that polls, votes, actions and tokens are looked up by",
+		Run:         createIndexes,
+	},
+	{
+		Name:        "certify-closed-polls",
+		Description: "Certify the current results of polls that closed before results were certified",
+		Run:         certifyClosedPolls,
+	},
+}
+
+// PendingMigrations returns the migrations that haven't been run yet, in the order they should run
+func PendingMigrations(ctx context.Context) ([]Migration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := Client.Database(db).Collection("migrations").Find(ctx, map[string]interface{}{})
+	if err != nil {
+		return nil, err
+	}
+	var applied []AppliedMigration
+	err = cursor.All(ctx, &applied)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[string]bool, len(applied))
+	for _, migration := range applied {
+		done[migration.Name] = true
+	}
+	pending := make([]Migration, 0)
+	for _, migration := range Migrations {
+		if !done[migration.Name] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// ApplyMigration runs a migration and records that it has been run
+func ApplyMigration(ctx context.Context, migration Migration, appliedBy string) error {
+	if err := migration.Run(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := Client.Database(db).Collection("migrations").InsertOne(ctx, AppliedMigration{
+		Name:      migration.Name,
+		AppliedAt: time.Now(),
+		AppliedBy: appliedBy,
+	})
+	return err
+}
+
+func backfillEligibility(ctx context.Context) error {
+	polls, err := GetPolls(ctx, map[string]interface{}{
+		"allowedUsers": map[string]interface{}{"$type": "array"},
+		"eligibility":  map[string]interface{}{"$exists": false},
+	}, 0)
+	if err != nil {
+		return err
+	}
+	for _, poll := range polls {
+		// A poll whose eligibility changed since it was read has already been backfilled
+		if err := poll.SetEligibility(ctx, poll.EligibilityEntries()); err != nil && !errors.Is(err, ErrEligibilityChanged) {
+			return err
+		}
+	}
+	return nil
+}
+
+func createIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	defer cancel()
+
+	indexes := map[string][]mongo.IndexModel{
+		"polls":              {{Keys: bson.D{{Key: "open", Value: 1}, {Key: "gatekeep", Value: 1}}}},
+		"votes":              {{Keys: bson.D{{Key: "pollId", Value: 1}}}},
+		"voters":             {{Keys: bson.D{{Key: "pollId", Value: 1}, {Key: "userId", Value: 1}}}, {Keys: bson.D{{Key: "userId", Value: 1}}}},
+		"actions":            {{Keys: bson.D{{Key: "pollId", Value: 1}, {Key: "date", Value: -1}}}, {Keys: bson.D{{Key: "date", Value: -1}}}},
+		"tokens":             {{Keys: bson.D{{Key: "hash", Value: 1}}}},
+		"webhooks":           {{Keys: bson.D{{Key: "events", Value: 1}}}},
+		"webhook_deliveries": {{Keys: bson.D{{Key: "date", Value: -1}}}},
+		// one appeal per user per poll, so a double submit can't file two
+		"appeals": {{Keys: bson.D{{Key: "pollId", Value: 1}, {Key: "userId", Value: 1}}, Options: options.Index().SetUnique(true)}},
+	}
+	for collection, models := range indexes {
+		_, err := Client.Database(db).Collection(collection).Indexes().CreateMany(ctx, models)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func certifyClosedPolls(ctx context.Context) error {
+	_, err := Client.Database(db).Collection("certified_results").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "pollId", Value: 1}, {Key: "certifiedAt", Value: -1}},
+	})
+	if err != nil {
+		return err
+	}
+	polls, err := GetPolls(ctx, map[string]interface{}{"open": false}, 0)
+	if err != nil {
+		return err
+	}
+	for _, poll := range polls {
+		_, err := poll.GetCertifiedResult(ctx)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ErrNotCertified) {
+			return err
+		}
+		if _, err := poll.Certify(ctx, "migration"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
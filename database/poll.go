@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"math"
 	"slices"
 	"sort"
@@ -11,6 +12,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	"github.com/computersciencehouse/vote/logging"
 )
@@ -29,14 +31,46 @@ type Poll struct {
 	AllowedUsers  []string  `bson:"allowedUsers"`
 	AllowWriteIns bool      `bson:"writeins"`
 
+	// OIDC groups the poll is restricted to, their members are snapshotted into AllowedUsers when it is created
+	Audience []string `bson:"audience,omitempty"`
+
+	// Records why each user in AllowedUsers is allowed to vote
+	// AllowedUsers is kept alongside this so existing queries and quorum math keep working
+	Eligibility []EligibilityEntry `bson:"eligibility,omitempty"`
+	// Bumped on every change to Eligibility, so replacing it can't overwrite a concurrent change
+	EligibilityVersion int `bson:"eligibilityVersion,omitempty"`
+
+	// Counts ballots let in while the poll was open, so certifying it can wait for any still being saved
+	BallotsAccepted int `bson:"ballotsAccepted,omitempty"`
+
+	// Set once webhooks have been told the poll reached quorum, so they're only told once
+	QuorumNotified bool `bson:"quorumNotified,omitempty"`
+
 	// Prevent this poll from having progress displayed
 	// This is important for events like elections where the results shouldn't be visible mid vote
 	Hidden bool `bson:"hidden"`
 }
 
+type EligibilityEntry struct {
+	Username string    `bson:"username"`
+	Source   string    `bson:"source"`
+	AddedBy  string    `bson:"addedBy"`
+	AddedAt  time.Time `bson:"addedAt"`
+}
+
 const POLL_TYPE_SIMPLE = "simple"
 const POLL_TYPE_RANKED = "ranked"
 
+// Sources a user's eligibility to vote in a poll can come from
+const ELIGIBILITY_GATEKEEP = "gatekeep"
+const ELIGIBILITY_WAIVER = "waiver"
+const ELIGIBILITY_MANUAL = "manual"
+const ELIGIBILITY_APPEAL = "appeal"
+const ELIGIBILITY_AUDIENCE = "audience"
+
+// ELIGIBILITY_LEGACY marks users on polls created before eligibility was tracked, where the reason is unknown
+const ELIGIBILITY_LEGACY = "legacy"
+
 func GetPoll(ctx context.Context, id string) (*Poll, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -50,20 +84,72 @@ func GetPoll(ctx context.Context, id string) (*Poll, error) {
 	return &poll, nil
 }
 
+// ErrPollClosed is returned when a poll is closed by the time a ballot is cast in it, or it is closed again
+var ErrPollClosed = errors.New("poll is closed")
+
+// Close stops a poll accepting ballots, returning ErrPollClosed if it already was closed
 func (poll *Poll) Close(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	objId, _ := primitive.ObjectIDFromHex(poll.Id)
 
-	_, err := Client.Database(db).Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"open": false}})
+	result, err := Client.Database(db).Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId, "open": true}, map[string]interface{}{"$set": map[string]interface{}{"open": false}})
 	if err != nil {
 		return err
 	}
+	if result.MatchedCount == 0 {
+		return ErrPollClosed
+	}
+	poll.Open = false
 
 	return nil
 }
 
+// acceptBallot counts a ballot about to be saved, returning ErrPollClosed if the poll has closed
+//
+// The poll is checked and the ballot counted in one update, so no ballot can be let in after Close
+func acceptBallot(ctx context.Context, pollId primitive.ObjectID) error {
+	result, err := Client.Database(db).Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": pollId, "open": true}, map[string]interface{}{"$inc": map[string]interface{}{"ballotsAccepted": 1}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrPollClosed
+	}
+	return nil
+}
+
+// releaseBallot uncounts a ballot that acceptBallot let in but that couldn't be saved, so certifying doesn't wait for it
+//
+// It gets its own timeout, since saving the ballot may have failed because ctx ran out
+func releaseBallot(ctx context.Context, pollId primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	_, err := Client.Database(db).Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": pollId}, map[string]interface{}{"$inc": map[string]interface{}{"ballotsAccepted": -1}})
+	return err
+}
+
+// Reopen lets a closed poll accept votes again, voiding its certified results as they no longer count every ballot
+func (poll *Poll) Reopen(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+
+	_, err := Client.Database(db).Collection("polls").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"open": true}})
+	if err != nil {
+		return err
+	}
+	poll.Open = true
+
+	_, err = Client.Database(db).Collection("certified_results").UpdateMany(ctx,
+		map[string]interface{}{"pollId": objId, "voided": map[string]interface{}{"$ne": true}},
+		map[string]interface{}{"$set": map[string]interface{}{"voided": true, "voidedAt": time.Now()}})
+	return err
+}
+
 func (poll *Poll) Hide(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -74,10 +160,153 @@ func (poll *Poll) Hide(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	poll.Hidden = true
 
 	return nil
 }
 
+// MarkQuorumNotified records that webhooks are being told the poll reached quorum
+//
+// Returns false if that was already recorded, by this or another instance
+func (poll *Poll) MarkQuorumNotified(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+
+	result, err := Client.Database(db).Collection("polls").UpdateOne(ctx,
+		map[string]interface{}{"_id": objId, "quorumNotified": map[string]interface{}{"$ne": true}},
+		map[string]interface{}{"$set": map[string]interface{}{"quorumNotified": true}})
+	if err != nil {
+		return false, err
+	}
+	poll.QuorumNotified = true
+
+	return result.MatchedCount == 1, nil
+}
+
+// EligibilityEntries returns the eligibility records for a poll, synthesising legacy
+// records from AllowedUsers for polls created before sources were tracked
+func (poll *Poll) EligibilityEntries() []EligibilityEntry {
+	if poll.Eligibility != nil || poll.AllowedUsers == nil {
+		return poll.Eligibility
+	}
+	entries := make([]EligibilityEntry, 0, len(poll.AllowedUsers))
+	for _, user := range poll.AllowedUsers {
+		entries = append(entries, EligibilityEntry{
+			Username: user,
+			Source:   ELIGIBILITY_LEGACY,
+			AddedAt:  poll.OpenedTime,
+		})
+	}
+	return entries
+}
+
+// ErrEligibilityChanged is returned by SetEligibility when the poll's eligibility was changed since it was read
+var ErrEligibilityChanged = errors.New("poll eligibility was changed by someone else")
+
+// eligibilityVersionFilter matches the poll at the version of its eligibility that was read, polls that have
+// never had it changed don't store a version
+func (poll *Poll) eligibilityVersionFilter() map[string]interface{} {
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+	if poll.EligibilityVersion == 0 {
+		return map[string]interface{}{"_id": objId, "eligibilityVersion": map[string]interface{}{"$in": bson.A{0, nil}}}
+	}
+	return map[string]interface{}{"_id": objId, "eligibilityVersion": poll.EligibilityVersion}
+}
+
+// SetEligibility replaces the eligibility records of a poll, keeping AllowedUsers in sync
+//
+// Entries are deduplicated by username, the first entry for a user wins. Returns ErrEligibilityChanged
+// if the eligibility was changed since the poll was read, so it should be reread and the change retried
+func (poll *Poll) SetEligibility(ctx context.Context, entries []EligibilityEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	entries = DedupeEligibility(entries)
+	allowedUsers := EligibleUsernames(entries)
+
+	result, err := Client.Database(db).Collection("polls").UpdateOne(ctx, poll.eligibilityVersionFilter(), map[string]interface{}{
+		"$set": map[string]interface{}{"eligibility": entries, "allowedUsers": allowedUsers},
+		"$inc": map[string]interface{}{"eligibilityVersion": 1},
+	})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrEligibilityChanged
+	}
+
+	poll.Eligibility = entries
+	poll.AllowedUsers = allowedUsers
+	poll.EligibilityVersion++
+	return nil
+}
+
+// AddEligibility allows one more user to vote in a poll, returning false if they already could
+//
+// The entry is only pushed if the user isn't already in AllowedUsers, so concurrent additions can't
+// duplicate or drop each other
+func (poll *Poll) AddEligibility(ctx context.Context, entry EligibilityEntry) (bool, error) {
+	// Legacy polls only have AllowedUsers, their entries need to be stored before anything can be pushed
+	if poll.Eligibility == nil && poll.AllowedUsers != nil {
+		err := poll.SetEligibility(ctx, poll.EligibilityEntries())
+		if err != nil && !errors.Is(err, ErrEligibilityChanged) {
+			return false, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(poll.Id)
+
+	var updated Poll
+	err := Client.Database(db).Collection("polls").FindOneAndUpdate(ctx,
+		map[string]interface{}{"_id": objId, "allowedUsers": map[string]interface{}{"$ne": entry.Username}},
+		map[string]interface{}{
+			"$push":     map[string]interface{}{"eligibility": entry},
+			"$addToSet": map[string]interface{}{"allowedUsers": entry.Username},
+			"$inc":      map[string]interface{}{"eligibilityVersion": 1},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&updated)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	poll.Eligibility = updated.Eligibility
+	poll.AllowedUsers = updated.AllowedUsers
+	poll.EligibilityVersion = updated.EligibilityVersion
+	return true, nil
+}
+
+// DedupeEligibility removes all but the first eligibility entry for each user
+func DedupeEligibility(entries []EligibilityEntry) []EligibilityEntry {
+	seen := make(map[string]bool)
+	deduped := make([]EligibilityEntry, 0, len(entries))
+	for _, entry := range entries {
+		if seen[entry.Username] {
+			continue
+		}
+		seen[entry.Username] = true
+		deduped = append(deduped, entry)
+	}
+	return deduped
+}
+
+// EligibleUsernames returns the usernames from a list of eligibility entries
+func EligibleUsernames(entries []EligibilityEntry) []string {
+	users := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		users = append(users, entry.Username)
+	}
+	return users
+}
+
 func CreatePoll(ctx context.Context, poll *Poll) (string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -107,6 +336,34 @@ func GetOpenPolls(ctx context.Context) ([]*Poll, error) {
 	return polls, nil
 }
 
+// CountOpenPolls returns how many polls are currently open
+func CountOpenPolls(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	return Client.Database(db).Collection("polls").CountDocuments(ctx, map[string]interface{}{"open": true})
+}
+
+// GetPolls returns the most recently created polls, filter limits which are returned
+func GetPolls(ctx context.Context, filter map[string]interface{}, limit int64) ([]*Poll, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(map[string]interface{}{"_id": -1}).SetLimit(limit)
+	cursor, err := Client.Database(db).Collection("polls").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var polls []*Poll
+	err = cursor.All(ctx, &polls)
+	if err != nil {
+		return nil, err
+	}
+
+	return polls, nil
+}
+
 func GetOpenGatekeepPolls(ctx context.Context) ([]*Poll, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -208,6 +465,12 @@ func calculateRankedResult(ctx context.Context, votesRaw []RankedVote) ([]map[st
 	votes := make([][]string, 0)
 	finalResult := make([]map[string]int, 0)
 
+	// every vote is from the same poll, so any of them tells us which poll the round reports are for
+	logger := logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "calculateRankedResult"})
+	if len(votesRaw) > 0 {
+		logger = logger.WithFields(logrus.Fields{"poll": votesRaw[0].PollId.Hex()})
+	}
+
 	//change ranked votes from a map (which is unordered) to a slice of votes (which is ordered)
 	//order is from first preference to last preference
 	for _, vote := range votesRaw {
@@ -247,8 +510,7 @@ func calculateRankedResult(ctx context.Context, votesRaw []RankedVote) ([]map[st
 		eliminated = append(eliminated, minPerson...)
 		finalResult = append(finalResult, tallied)
 
-		// TODO this should probably include some poll identifier
-		logging.Logger.WithFields(logrus.Fields{"round": round, "tallies": tallied, "threshold": voteCount / 2}).Debug("round report")
+		logger.WithFields(logrus.Fields{"round": round, "tallies": tallied, "threshold": voteCount / 2}).Debug("round report")
 
 		// If one person has all the votes, they win
 		if len(tallied) == 1 {
@@ -277,6 +539,9 @@ func calculateRankedResult(ctx context.Context, votesRaw []RankedVote) ([]map[st
 
 }
 
+// GetResult counts the votes in a poll
+//
+// Closed polls should be shown with GetOfficialResult instead. Bump TALLY_VERSION when changing how votes are counted
 func (poll *Poll) GetResult(ctx context.Context) ([]map[string]int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -13,12 +14,19 @@ type RankedVote struct {
 	Options map[string]int     `bson:"options"`
 }
 
+// CastRankedVote saves a ballot and who cast it, returning ErrPollClosed if the poll has closed
 func CastRankedVote(ctx context.Context, vote *RankedVote, voter *Voter) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	if err := acceptBallot(ctx, vote.PollId); err != nil {
+		return err
+	}
 	_, err := Client.Database(db).Collection("votes").InsertOne(ctx, vote)
 	if err != nil {
+		if releaseErr := releaseBallot(ctx, vote.PollId); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
 		return err
 	}
 	_, err = Client.Database(db).Collection("voters").InsertOne(ctx, voter)
@@ -28,3 +36,28 @@ func CastRankedVote(ctx context.Context, vote *RankedVote, voter *Voter) error {
 
 	return nil
 }
+
+// GetRankedVotes returns every ballot cast in a ranked poll
+func GetRankedVotes(ctx context.Context, pollId string) ([]RankedVote, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pId, _ := primitive.ObjectIDFromHex(pollId)
+	cursor, err := Client.Database(db).Collection("votes").Find(ctx, map[string]interface{}{"pollId": pId})
+	if err != nil {
+		return nil, err
+	}
+
+	var votes []RankedVote
+	err = cursor.All(ctx, &votes)
+	if err != nil {
+		return nil, err
+	}
+
+	return votes, nil
+}
+
+// Preferences returns the options on a ballot from first preference to last, in the order they are counted
+func (vote RankedVote) Preferences() []string {
+	return orderOptions(context.Background(), vote.Options)
+}
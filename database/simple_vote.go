@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -18,12 +19,19 @@ type SimpleResult struct {
 	Count  int    `bson:"count"`
 }
 
+// CastSimpleVote saves a ballot and who cast it, returning ErrPollClosed if the poll has closed
 func CastSimpleVote(ctx context.Context, vote *SimpleVote, voter *Voter) error {
 	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
+	if err := acceptBallot(ctx, vote.PollId); err != nil {
+		return err
+	}
 	_, err := Client.Database(db).Collection("votes").InsertOne(ctx, vote)
 	if err != nil {
+		if releaseErr := releaseBallot(ctx, vote.PollId); releaseErr != nil {
+			return errors.Join(err, releaseErr)
+		}
 		return err
 	}
 	_, err = Client.Database(db).Collection("voters").InsertOne(ctx, voter)
@@ -33,3 +41,23 @@ func CastSimpleVote(ctx context.Context, vote *SimpleVote, voter *Voter) error {
 
 	return nil
 }
+
+// GetSimpleVotes returns every ballot cast in a simple poll
+func GetSimpleVotes(ctx context.Context, pollId string) ([]SimpleVote, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pId, _ := primitive.ObjectIDFromHex(pollId)
+	cursor, err := Client.Database(db).Collection("votes").Find(ctx, map[string]interface{}{"pollId": pId})
+	if err != nil {
+		return nil, err
+	}
+
+	var votes []SimpleVote
+	err = cursor.All(ctx, &votes)
+	if err != nil {
+		return nil, err
+	}
+
+	return votes, nil
+}
@@ -0,0 +1,131 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// APIToken lets a service use the API without logging in
+//
+// Only a hash of the token is stored, the token itself is shown once when it is created
+type APIToken struct {
+	Id     string   `bson:"_id,omitempty"`
+	Name   string   `bson:"name"`
+	Hash   string   `bson:"hash"`
+	Scopes []string `bson:"scopes"`
+	// The user the token acts as, without one the token can only read
+	ActingUser string    `bson:"actingUser,omitempty"`
+	CreatedBy  string    `bson:"createdBy"`
+	CreatedAt  time.Time `bson:"createdAt"`
+	LastUsed   time.Time `bson:"lastUsed,omitempty"`
+	Revoked    bool      `bson:"revoked"`
+	RevokedBy  string    `bson:"revokedBy,omitempty"`
+	RevokedAt  time.Time `bson:"revokedAt,omitempty"`
+}
+
+// Impersonates reports whether the token acts as someone other than the RTP who minted it
+func (token *APIToken) Impersonates() bool {
+	return token.ActingUser != "" && token.ActingUser != token.CreatedBy
+}
+
+// Scopes an API token can be granted
+const SCOPE_POLLS_READ = "polls:read"
+const SCOPE_POLLS_CREATE = "polls:create"
+const SCOPE_POLLS_VOTE = "polls:vote"
+const SCOPE_POLLS_MANAGE = "polls:manage"
+const SCOPE_RESULTS_READ = "results:read"
+
+var Scopes = []string{SCOPE_POLLS_READ, SCOPE_POLLS_CREATE, SCOPE_POLLS_VOTE, SCOPE_POLLS_MANAGE, SCOPE_RESULTS_READ}
+
+var ErrTokenNotFound = errors.New("api token not found")
+
+func CreateToken(ctx context.Context, token *APIToken) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := Client.Database(db).Collection("tokens").InsertOne(ctx, token)
+	if err != nil {
+		return "", err
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+// GetTokenByHash returns the unrevoked token with a hash, or ErrTokenNotFound
+func GetTokenByHash(ctx context.Context, hash string) (*APIToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var token APIToken
+	err := Client.Database(db).Collection("tokens").FindOne(ctx, map[string]interface{}{"hash": hash, "revoked": false}).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+func GetTokens(ctx context.Context) ([]*APIToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(map[string]interface{}{"createdAt": -1})
+	cursor, err := Client.Database(db).Collection("tokens").Find(ctx, map[string]interface{}{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []*APIToken
+	err = cursor.All(ctx, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// RevokeToken stops a token from being used, returning ErrTokenNotFound if it doesn't exist or is already revoked
+func RevokeToken(ctx context.Context, id string, revokedBy string) (*APIToken, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(id)
+	var token APIToken
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	err := Client.Database(db).Collection("tokens").FindOneAndUpdate(ctx,
+		map[string]interface{}{"_id": objId, "revoked": false},
+		map[string]interface{}{"$set": map[string]interface{}{"revoked": true, "revokedBy": revokedBy, "revokedAt": time.Now()}},
+		opts,
+	).Decode(&token)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrTokenNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// MarkUsed records that a token was just used
+func (token *APIToken) MarkUsed(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(token.Id)
+	now := time.Now()
+	_, err := Client.Database(db).Collection("tokens").UpdateOne(ctx, map[string]interface{}{"_id": objId}, map[string]interface{}{"$set": map[string]interface{}{"lastUsed": now}})
+	if err != nil {
+		return err
+	}
+	token.LastUsed = now
+
+	return nil
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Voter struct {
@@ -28,3 +30,76 @@ func HasVoted(ctx context.Context, pollId, userId string) (bool, error) {
 	}
 	return count > 0, nil
 }
+
+// pollObjectIds converts poll ids for querying, skipping any that aren't valid
+func pollObjectIds(pollIds []string) []primitive.ObjectID {
+	objIds := make([]primitive.ObjectID, 0, len(pollIds))
+	for _, pollId := range pollIds {
+		if objId, err := primitive.ObjectIDFromHex(pollId); err == nil {
+			objIds = append(objIds, objId)
+		}
+	}
+	return objIds
+}
+
+// GetVotedPollIds returns which of the given polls a user has voted in
+func GetVotedPollIds(ctx context.Context, userId string, pollIds []string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := Client.Database(db).Collection("voters").Find(ctx, map[string]interface{}{
+		"userId": userId,
+		"pollId": map[string]interface{}{"$in": pollObjectIds(pollIds)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var voters []Voter
+	if err := cursor.All(ctx, &voters); err != nil {
+		return nil, err
+	}
+	voted := make(map[string]bool, len(voters))
+	for _, voter := range voters {
+		voted[voter.PollId.Hex()] = true
+	}
+	return voted, nil
+}
+
+// CountVoters returns how many users have voted in each of the given polls
+func CountVoters(ctx context.Context, pollIds []string) (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := Client.Database(db).Collection("voters").Aggregate(ctx, mongo.Pipeline{
+		{{
+			Key: "$match", Value: bson.D{
+				{Key: "pollId", Value: bson.D{{Key: "$in", Value: pollObjectIds(pollIds)}}},
+			},
+		}},
+		{{
+			Key: "$group", Value: bson.D{
+				{Key: "_id", Value: "$pollId"},
+				{Key: "count", Value: bson.D{
+					{Key: "$sum", Value: 1},
+				}},
+			},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		PollId primitive.ObjectID `bson:"_id"`
+		Count  int                `bson:"count"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int, len(results))
+	for _, result := range results {
+		counts[result.PollId.Hex()] = result.Count
+	}
+	return counts, nil
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrChangeStreamsUnsupported is returned by WatchPolls when Mongo isn't a replica set, like the development server
+var ErrChangeStreamsUnsupported = errors.New("change streams need mongo to be a replica set")
+
+// ErrChangeStreamHistoryLost is returned when a change stream can't resume because Mongo no longer has the
+// changes since its resume token, so the stream has to be opened again from now
+var ErrChangeStreamHistoryLost = errors.New("changes since the resume token are no longer in the oplog")
+
+// Mongo's error codes for opening a change stream on a standalone server, and resuming one too late
+const (
+	changeStreamsUnsupportedCode = 40573
+	changeStreamHistoryLostCode  = 286
+)
+
+// changeStreamError Turns Mongo's errors for change streams into ones callers can check for
+func changeStreamError(err error) error {
+	var cmdErr mongo.CommandError
+	if errors.As(err, &cmdErr) {
+		switch cmdErr.Code {
+		case changeStreamsUnsupportedCode:
+			return ErrChangeStreamsUnsupported
+		case changeStreamHistoryLostCode:
+			return ErrChangeStreamHistoryLost
+		}
+	}
+	return err
+}
+
+// ChangeKind is what happened to a poll
+type ChangeKind int
+
+const (
+	// PollCreated is a new poll
+	PollCreated ChangeKind = iota
+	// PollUpdated is any change to a poll other than opening or closing it
+	PollUpdated
+	// PollOpenChanged is a poll being closed or reopened
+	PollOpenChanged
+	// BallotCast is an anonymous ballot being added to a poll
+	BallotCast
+	// VoterRecorded is a user being marked as having voted in a poll
+	VoterRecorded
+)
+
+// PollChange is a change to a poll or its votes, made by any instance
+type PollChange struct {
+	PollId string
+	Kind   ChangeKind
+	// Voter is who voted, for VoterRecorded
+	Voter string
+}
+
+// PollWatcher follows changes to polls and votes as they're made
+type PollWatcher struct {
+	stream *mongo.ChangeStream
+}
+
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	Ns            struct {
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey struct {
+		Id primitive.ObjectID `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument struct {
+		PollId primitive.ObjectID `bson:"pollId"`
+		UserId string             `bson:"userId"`
+	} `bson:"fullDocument"`
+	UpdateDescription struct {
+		UpdatedFields map[string]interface{} `bson:"updatedFields"`
+	} `bson:"updateDescription"`
+}
+
+// Fields of a poll that are only bookkeeping, changing them doesn't change anything viewers see
+var internalPollFields = map[string]bool{
+	"ballotsAccepted":    true,
+	"quorumNotified":     true,
+	"eligibility":        true,
+	"eligibilityVersion": true,
+	"allowedUsers":       true,
+}
+
+// onlyInternalFields Reports whether an update only changed bookkeeping fields, like the count of ballots let in on every vote
+func (event changeEvent) onlyInternalFields() bool {
+	fields := event.UpdateDescription.UpdatedFields
+	if event.OperationType != "update" || len(fields) == 0 {
+		return false
+	}
+	for field := range fields {
+		// pushing to an array updates a path like eligibility.3
+		root, _, _ := strings.Cut(field, ".")
+		if !internalPollFields[root] {
+			return false
+		}
+	}
+	return true
+}
+
+// pollChange Works out which poll a change event is about
+func (event changeEvent) pollChange() (PollChange, bool) {
+	switch event.Ns.Coll {
+	case "polls":
+		if event.onlyInternalFields() {
+			return PollChange{}, false
+		}
+		change := PollChange{PollId: event.DocumentKey.Id.Hex(), Kind: PollUpdated}
+		if event.OperationType == "insert" {
+			change.Kind = PollCreated
+		} else if _, ok := event.UpdateDescription.UpdatedFields["open"]; ok {
+			change.Kind = PollOpenChanged
+		}
+		return change, true
+	case "votes":
+		if event.FullDocument.PollId.IsZero() {
+			return PollChange{}, false
+		}
+		return PollChange{PollId: event.FullDocument.PollId.Hex(), Kind: BallotCast}, true
+	case "voters":
+		if event.FullDocument.PollId.IsZero() || event.FullDocument.UserId == "" {
+			return PollChange{}, false
+		}
+		return PollChange{PollId: event.FullDocument.PollId.Hex(), Kind: VoterRecorded, Voter: event.FullDocument.UserId}, true
+	default:
+		return PollChange{}, false
+	}
+}
+
+// WatchPolls opens a change stream on polls, votes and voters
+//
+// With a resume token from an earlier watcher, every change after the last one it saw is seen, otherwise only
+// changes made after it's opened are. Returns ErrChangeStreamHistoryLost if the token is too old to resume from
+func WatchPolls(ctx context.Context, resumeToken bson.Raw) (*PollWatcher, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.ChangeStream()
+	if resumeToken != nil {
+		opts.SetStartAfter(resumeToken)
+	}
+	stream, err := Client.Database(db).Watch(ctx, mongo.Pipeline{
+		{{
+			Key: "$match", Value: bson.D{
+				{Key: "ns.coll", Value: bson.D{{Key: "$in", Value: bson.A{"polls", "votes", "voters"}}}},
+				{Key: "operationType", Value: bson.D{{Key: "$in", Value: bson.A{"insert", "update", "replace"}}}},
+			},
+		}},
+	}, opts)
+	if err != nil {
+		return nil, changeStreamError(err)
+	}
+	return &PollWatcher{stream: stream}, nil
+}
+
+// Next waits for the next change, returning an error if the stream fails or ctx is done
+func (watcher *PollWatcher) Next(ctx context.Context) (PollChange, error) {
+	for watcher.stream.Next(ctx) {
+		var event changeEvent
+		if err := watcher.stream.Decode(&event); err != nil {
+			return PollChange{}, err
+		}
+		if change, ok := event.pollChange(); ok {
+			return change, nil
+		}
+	}
+	if err := watcher.stream.Err(); err != nil {
+		return PollChange{}, changeStreamError(err)
+	}
+	return PollChange{}, ctx.Err()
+}
+
+// ResumeToken returns where the watcher is up to, so a new watcher can carry on from there if this one fails
+func (watcher *PollWatcher) ResumeToken() bson.Raw {
+	return watcher.stream.ResumeToken()
+}
+
+// Close stops watching
+func (watcher *PollWatcher) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return watcher.stream.Close(ctx)
+}
@@ -0,0 +1,195 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestPollChange(t *testing.T) {
+	pollId := primitive.NewObjectID()
+	voteId := primitive.NewObjectID()
+
+	tests := []struct {
+		name   string
+		event  bson.M
+		change PollChange
+		ok     bool
+	}{
+		{
+			name: "poll created",
+			event: bson.M{
+				"operationType": "insert",
+				"ns":            bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":   bson.M{"_id": pollId},
+				"fullDocument":  bson.M{"_id": pollId, "title": "Test", "open": true},
+			},
+			change: PollChange{PollId: pollId.Hex(), Kind: PollCreated},
+			ok:     true,
+		},
+		{
+			name: "poll hidden",
+			event: bson.M{
+				"operationType":     "update",
+				"ns":                bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":       bson.M{"_id": pollId},
+				"updateDescription": bson.M{"updatedFields": bson.M{"hidden": true}},
+			},
+			change: PollChange{PollId: pollId.Hex(), Kind: PollUpdated},
+			ok:     true,
+		},
+		{
+			name: "poll closed",
+			event: bson.M{
+				"operationType":     "update",
+				"ns":                bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":       bson.M{"_id": pollId},
+				"updateDescription": bson.M{"updatedFields": bson.M{"open": false}},
+			},
+			change: PollChange{PollId: pollId.Hex(), Kind: PollOpenChanged},
+			ok:     true,
+		},
+		{
+			name: "poll reopened",
+			event: bson.M{
+				"operationType":     "update",
+				"ns":                bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":       bson.M{"_id": pollId},
+				"updateDescription": bson.M{"updatedFields": bson.M{"open": true}},
+			},
+			change: PollChange{PollId: pollId.Hex(), Kind: PollOpenChanged},
+			ok:     true,
+		},
+		{
+			name: "poll replaced",
+			event: bson.M{
+				"operationType": "replace",
+				"ns":            bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":   bson.M{"_id": pollId},
+				"fullDocument":  bson.M{"_id": pollId, "title": "Test", "open": true},
+			},
+			change: PollChange{PollId: pollId.Hex(), Kind: PollUpdated},
+			ok:     true,
+		},
+		{
+			name: "ballot let in",
+			event: bson.M{
+				"operationType":     "update",
+				"ns":                bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":       bson.M{"_id": pollId},
+				"updateDescription": bson.M{"updatedFields": bson.M{"ballotsAccepted": 3}},
+			},
+		},
+		{
+			name: "eligible user added",
+			event: bson.M{
+				"operationType": "update",
+				"ns":            bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":   bson.M{"_id": pollId},
+				"updateDescription": bson.M{"updatedFields": bson.M{
+					"eligibility.4":      bson.M{"username": "member", "source": "manual"},
+					"allowedUsers.4":     "member",
+					"eligibilityVersion": 2,
+				}},
+			},
+		},
+		{
+			name: "quorum notified",
+			event: bson.M{
+				"operationType":     "update",
+				"ns":                bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":       bson.M{"_id": pollId},
+				"updateDescription": bson.M{"updatedFields": bson.M{"quorumNotified": true}},
+			},
+		},
+		{
+			name: "closed along with bookkeeping",
+			event: bson.M{
+				"operationType":     "update",
+				"ns":                bson.M{"db": "vote", "coll": "polls"},
+				"documentKey":       bson.M{"_id": pollId},
+				"updateDescription": bson.M{"updatedFields": bson.M{"open": false, "ballotsAccepted": 3}},
+			},
+			change: PollChange{PollId: pollId.Hex(), Kind: PollOpenChanged},
+			ok:     true,
+		},
+		{
+			name: "vote cast",
+			event: bson.M{
+				"operationType": "insert",
+				"ns":            bson.M{"db": "vote", "coll": "votes"},
+				"documentKey":   bson.M{"_id": voteId},
+				"fullDocument":  bson.M{"_id": voteId, "pollId": pollId, "option": "Yes"},
+			},
+			change: PollChange{PollId: pollId.Hex(), Kind: BallotCast},
+			ok:     true,
+		},
+		{
+			name: "voter recorded",
+			event: bson.M{
+				"operationType": "insert",
+				"ns":            bson.M{"db": "vote", "coll": "voters"},
+				"documentKey":   bson.M{"_id": voteId},
+				"fullDocument":  bson.M{"_id": voteId, "pollId": pollId, "userId": "member"},
+			},
+			change: PollChange{PollId: pollId.Hex(), Kind: VoterRecorded, Voter: "member"},
+			ok:     true,
+		},
+		{
+			name: "vote without a poll",
+			event: bson.M{
+				"operationType": "insert",
+				"ns":            bson.M{"db": "vote", "coll": "votes"},
+				"documentKey":   bson.M{"_id": voteId},
+				"fullDocument":  bson.M{"_id": voteId, "option": "Yes"},
+			},
+		},
+		{
+			name: "other collection",
+			event: bson.M{
+				"operationType": "insert",
+				"ns":            bson.M{"db": "vote", "coll": "actions"},
+				"documentKey":   bson.M{"_id": voteId},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			raw, err := bson.Marshal(test.event)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var event changeEvent
+			if err := bson.Unmarshal(raw, &event); err != nil {
+				t.Fatal(err)
+			}
+			change, ok := event.pollChange()
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.change, change)
+		})
+	}
+}
+
+func TestChangeStreamError(t *testing.T) {
+	other := errors.New("connection reset")
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{name: "standalone server", err: mongo.CommandError{Code: 40573}, want: ErrChangeStreamsUnsupported},
+		{name: "resume token too old", err: mongo.CommandError{Code: 286}, want: ErrChangeStreamHistoryLost},
+		{name: "other command error", err: mongo.CommandError{Code: 11600}, want: mongo.CommandError{Code: 11600}},
+		{name: "network error", err: other, want: other},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.want, changeStreamError(test.err))
+		})
+	}
+}
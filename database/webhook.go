@@ -0,0 +1,139 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Webhook is a URL that gets told about poll events
+type Webhook struct {
+	Id  string `bson:"_id,omitempty"`
+	URL string `bson:"url"`
+	// Used to sign payloads, shown once when the webhook is created
+	Secret    string    `bson:"secret"`
+	Events    []string  `bson:"events"`
+	CreatedBy string    `bson:"createdBy"`
+	CreatedAt time.Time `bson:"createdAt"`
+}
+
+// WebhookDelivery is one attempt to deliver an event to a webhook
+type WebhookDelivery struct {
+	Id        string `bson:"_id,omitempty"`
+	WebhookId string `bson:"webhookId"`
+	// Shared by every attempt at delivering the same event
+	DeliveryId string    `bson:"deliveryId"`
+	Event      string    `bson:"event"`
+	Attempt    int       `bson:"attempt"`
+	StatusCode int       `bson:"statusCode,omitempty"`
+	Error      string    `bson:"error,omitempty"`
+	Success    bool      `bson:"success"`
+	Date       time.Time `bson:"date"`
+}
+
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+func CreateWebhook(ctx context.Context, webhook *Webhook) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	result, err := Client.Database(db).Collection("webhooks").InsertOne(ctx, webhook)
+	if err != nil {
+		return "", err
+	}
+	return result.InsertedID.(primitive.ObjectID).Hex(), nil
+}
+
+func GetWebhooks(ctx context.Context) ([]*Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(map[string]interface{}{"createdAt": -1})
+	cursor, err := Client.Database(db).Collection("webhooks").Find(ctx, map[string]interface{}{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var webhooks []*Webhook
+	err = cursor.All(ctx, &webhooks)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// GetWebhooksForEvent returns the webhooks subscribed to an event
+func GetWebhooksForEvent(ctx context.Context, event string) ([]*Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	cursor, err := Client.Database(db).Collection("webhooks").Find(ctx, map[string]interface{}{"events": event})
+	if err != nil {
+		return nil, err
+	}
+
+	var webhooks []*Webhook
+	err = cursor.All(ctx, &webhooks)
+	if err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook, returning ErrWebhookNotFound if it doesn't exist
+//
+// The delivery log for the webhook is kept
+func DeleteWebhook(ctx context.Context, id string) (*Webhook, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	objId, _ := primitive.ObjectIDFromHex(id)
+	var webhook Webhook
+	err := Client.Database(db).Collection("webhooks").FindOneAndDelete(ctx, map[string]interface{}{"_id": objId}).Decode(&webhook)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrWebhookNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &webhook, nil
+}
+
+func WriteWebhookDelivery(ctx context.Context, delivery *WebhookDelivery) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := Client.Database(db).Collection("webhook_deliveries").InsertOne(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetWebhookDeliveries returns the most recent delivery attempts across every webhook
+func GetWebhookDeliveries(ctx context.Context, limit int64) ([]*WebhookDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	opts := options.Find().SetSort(map[string]interface{}{"date": -1}).SetLimit(limit)
+	cursor, err := Client.Database(db).Collection("webhook_deliveries").Find(ctx, map[string]interface{}{}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var deliveries []*WebhookDelivery
+	err = cursor.All(ctx, &deliveries)
+	if err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
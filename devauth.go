@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/directory"
+	"github.com/gin-gonic/gin"
+)
+
+const devAuthCookie = "vote_dev_user"
+
+// DevAuth replaces CSH SSO for local development, letting you log in as any user in a static directory
+//
+// The cookie is just the username, so this must never be enabled anywhere real
+type DevAuth struct {
+	directory *directory.Static
+}
+
+func NewDevAuth(static *directory.Static) *DevAuth {
+	return &DevAuth{directory: static}
+}
+
+func (auth *DevAuth) Routes(r *gin.Engine) {
+	r.GET("/auth/login", auth.getLogin)
+	r.POST("/auth/login", auth.postLogin)
+	r.GET("/auth/logout", auth.logout)
+}
+
+func (auth *DevAuth) Authenticate(c *gin.Context) bool {
+	username, err := c.Cookie(devAuthCookie)
+	if err != nil {
+		return false
+	}
+	user := auth.find(username)
+	if user == nil {
+		return false
+	}
+	c.Set(cshAuth.AuthKey, cshAuth.CSHClaims{
+		UserInfo: cshAuth.CSHUserInfo{
+			Username: user.Username,
+			FullName: user.FullName,
+			Groups:   user.Groups,
+		},
+	})
+	return true
+}
+
+func (auth *DevAuth) find(username string) *directory.StaticUser {
+	for _, user := range auth.directory.Users() {
+		if user.Username == username {
+			return &user
+		}
+	}
+	return nil
+}
+
+// getLogin Displays every user in the directory to pick from
+func (auth *DevAuth) getLogin(c *gin.Context) {
+	render(c, http.StatusOK, "devlogin.tmpl", gin.H{
+		"Users":    auth.directory.Users(),
+		"Referer":  c.Query("referer"),
+		"Username": "",
+		"FullName": "Not logged in",
+	})
+}
+
+// postLogin Logs in as the chosen user
+func (auth *DevAuth) postLogin(c *gin.Context) {
+	if !checkCSRF(c) {
+		csrfFailed(c)
+		return
+	}
+	user := auth.find(c.PostForm("username"))
+	if user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown user"})
+		return
+	}
+	c.SetCookie(devAuthCookie, user.Username, 0, "/", "", false, true)
+	referer := c.Query("referer")
+	if !strings.HasPrefix(referer, "/") || strings.HasPrefix(referer, "//") {
+		referer = "/"
+	}
+	c.Redirect(http.StatusFound, referer)
+}
+
+func (auth *DevAuth) logout(c *gin.Context) {
+	c.SetCookie(devAuthCookie, "", -1, "/", "", false, true)
+	c.Redirect(http.StatusFound, "/auth/login")
+}
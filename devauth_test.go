@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/computersciencehouse/vote/directory"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevAuth(t *testing.T) {
+	static, err := directory.LoadStatic("dev/directory.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	auth := NewDevAuth(static)
+	r.POST("/auth/login", auth.postLogin)
+	r.GET("/whoami", RequireAuth(auth), func(c *gin.Context) {
+		user := GetUserData(c)
+		c.JSON(http.StatusOK, gin.H{"username": user.Username, "evals": IsEvals(user), "active": IsActive(user)})
+	})
+
+	tests := []struct {
+		name     string
+		cookie   string
+		status   int
+		location string
+		body     string
+	}{
+		{name: "no cookie", status: http.StatusFound, location: "/auth/login?referer=%2Fwhoami"},
+		{name: "unknown user", cookie: "nobody", status: http.StatusFound, location: "/auth/login?referer=%2Fwhoami"},
+		{name: "evals", cookie: "evals", status: http.StatusOK, body: `{"active":true,"evals":true,"username":"evals"}`},
+		{name: "alumni", cookie: "alumni", status: http.StatusOK, body: `{"active":false,"evals":false,"username":"alumni"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: devAuthCookie, Value: test.cookie})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, test.status, w.Code)
+			assert.Equal(t, test.location, w.Header().Get("Location"))
+			if test.body != "" {
+				assert.JSONEq(t, test.body, w.Body.String())
+			}
+		})
+	}
+
+	t.Run("login", func(t *testing.T) {
+		form := url.Values{"username": {"eboard"}, csrfFormField: {csrfSignature("browser", "")}}
+		req := httptest.NewRequest(http.MethodPost, "/auth/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "browser"})
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusFound, w.Code)
+		cookies := w.Result().Cookies()
+		if assert.Len(t, cookies, 1) {
+			assert.Equal(t, devAuthCookie, cookies[0].Name)
+			assert.Equal(t, "eboard", cookies[0].Value)
+		}
+	})
+}
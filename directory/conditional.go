@@ -0,0 +1,104 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/computersciencehouse/vote/metrics"
+)
+
+// ErrGatekeepToken is returned when conditional rejects our token
+var ErrGatekeepToken = errors.New("conditional gatekeep token is incorrect")
+
+type ConditionalConfig struct {
+	// GatekeepURL is the gatekeep endpoint, eg https://conditional.csh.rit.edu/gatekeep
+	GatekeepURL string
+	// Token is sent to conditional as X-VOTE-TOKEN
+	Token string
+}
+
+// Conditional asks conditional which members meet the gatekeep requirements
+type Conditional struct {
+	config     ConditionalConfig
+	httpClient *http.Client
+}
+
+func NewConditional(config ConditionalConfig) *Conditional {
+	return &Conditional{
+		config:     config,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (conditional *Conditional) get(ctx context.Context, operation string, endpoint string) (_ []byte, err error) {
+	defer func() {
+		if err != nil {
+			metrics.DirectoryErrors.WithLabelValues("conditional", operation).Inc()
+		}
+	}()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-VOTE-TOKEN", conditional.config.Token)
+	resp, err := conditional.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("conditional returned %s", resp.Status)
+	}
+	if strings.Contains(string(b), "Users") {
+		return nil, ErrGatekeepToken
+	}
+	return b, nil
+}
+
+// CheckReachable makes sure conditional is up and accepts our token
+func (conditional *Conditional) CheckReachable(ctx context.Context) error {
+	_, err := conditional.get(ctx, "CheckReachable", conditional.config.GatekeepURL)
+	return err
+}
+
+// GetGatekeep Queries conditional to determine whether a user has met the gatekeep requirements
+func (conditional *Conditional) GetGatekeep(ctx context.Context, username string) (bool, error) {
+	b, err := conditional.get(ctx, "GetGatekeep", strings.TrimSuffix(conditional.config.GatekeepURL, "/")+"/"+url.PathEscape(username))
+	if err != nil {
+		return false, err
+	}
+	result := struct {
+		Gatekeep bool `json:"result"`
+	}{}
+	if err = json.Unmarshal(b, &result); err != nil {
+		return false, err
+	}
+	return result.Gatekeep, nil
+}
+
+// GetEligibleVoters returns the usernames of everyone conditional says meets gatekeep
+//
+// An error is returned if conditional could not be reached or rejected our token,
+// callers must not treat that as an empty list of voters
+func (conditional *Conditional) GetEligibleVoters(ctx context.Context) ([]string, error) {
+	b, err := conditional.get(ctx, "GetEligibleVoters", conditional.config.GatekeepURL)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]string, 0)
+	if err = json.Unmarshal(b, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
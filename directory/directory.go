@@ -0,0 +1,54 @@
+// Package directory looks up members of house, the groups they are in, and whether they meet gatekeep
+package directory
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrUserNotFound is returned when no user matches a username
+	ErrUserNotFound = errors.New("user not found")
+	// ErrGroupNotFound is returned when no group matches a name
+	ErrGroupNotFound = errors.New("group not found")
+)
+
+type User struct {
+	Uuid     string
+	Username string
+	FullName string
+	SlackUID string
+}
+
+// Directory is the source of truth for who members are
+//
+// Groups are always referred to by name, eg "active" or "eboard-evaluations", matching
+// the names in the groups claim of a user's OIDC token
+type Directory interface {
+	// GetUser looks up a user by their exact username, returning ErrUserNotFound if they don't exist
+	GetUser(ctx context.Context, username string) (*User, error)
+	// GetGroupMembers returns the members of a group, returning ErrGroupNotFound if it doesn't exist
+	GetGroupMembers(ctx context.Context, group string) ([]User, error)
+	// GetUserGroups returns the names of the groups a user is in
+	GetUserGroups(ctx context.Context, username string) ([]string, error)
+	// GetSlackUID returns a user's Slack ID, or an empty string if they haven't linked Slack
+	GetSlackUID(ctx context.Context, username string) (string, error)
+	// GetGatekeep returns whether a user currently meets the gatekeep requirements
+	GetGatekeep(ctx context.Context, username string) (bool, error)
+	// GetEligibleVoters returns the usernames of everyone who currently meets the gatekeep requirements
+	GetEligibleVoters(ctx context.Context) ([]string, error)
+}
+
+// CSH is the production directory, users and groups come from Keycloak and gatekeep status from conditional
+type CSH struct {
+	*Keycloak
+	*Conditional
+}
+
+// NewCSH creates a directory backed by Keycloak and conditional, and starts refreshing the Keycloak token
+func NewCSH(keycloak KeycloakConfig, conditional ConditionalConfig) *CSH {
+	return &CSH{
+		Keycloak:    NewKeycloak(keycloak),
+		Conditional: NewConditional(conditional),
+	}
+}
@@ -0,0 +1,391 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/metrics"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long before expiry the access token is refreshed
+	tokenRefreshMargin = 30 * time.Second
+	// Bounds for the backoff used when fetching a token fails
+	tokenMinBackoff = 5 * time.Second
+	tokenMaxBackoff = 5 * time.Minute
+	// Number of attempts made for a Keycloak request failing with a network error or 5xx
+	requestAttempts = 3
+	requestBackoff  = 250 * time.Millisecond
+	// Number of group members fetched per request
+	groupPageSize = 100
+)
+
+// ErrNoAccessToken is returned by CheckToken when there is no unexpired access token
+var ErrNoAccessToken = errors.New("no valid oidc access token")
+
+// ErrOIDCUnauthorized is returned when Keycloak rejects our access token, even after refreshing it
+var ErrOIDCUnauthorized = errors.New("oidc admin api rejected the access token")
+
+// OIDCError is returned when Keycloak responds with an unexpected status
+type OIDCError struct {
+	Method     string
+	StatusCode int
+	Status     string
+}
+
+func (err *OIDCError) Error() string {
+	return err.Method + ": unexpected response " + err.Status
+}
+
+type KeycloakConfig struct {
+	// Issuer is the realm's OIDC issuer, eg https://sso.csh.rit.edu/auth/realms/csh
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+}
+
+// Keycloak talks to the Keycloak admin API using a client credentials grant
+//
+// It is safe for concurrent use, the access token is refreshed in the background
+// before it expires, and immediately if Keycloak ever responds with a 401
+type Keycloak struct {
+	config     KeycloakConfig
+	adminBase  string
+	httpClient *http.Client
+
+	tokenLock   sync.RWMutex
+	accessToken string
+	tokenExpiry time.Time
+	// Serialises token fetches so concurrent 401s only cause one refresh
+	refreshLock sync.Mutex
+
+	groupLock  sync.RWMutex
+	groupCache map[string]string
+
+	quit     chan struct{}
+	quitOnce sync.Once
+}
+
+type keycloakUser struct {
+	Id         string              `json:"id"`
+	Username   string              `json:"username"`
+	FirstName  string              `json:"firstName"`
+	LastName   string              `json:"lastName"`
+	Attributes map[string][]string `json:"attributes"`
+}
+
+func (user keycloakUser) toUser() User {
+	// attributes are a list of values, there will only ever be one slack ID
+	slackUID := ""
+	if slackIDs := user.Attributes["slackuid"]; len(slackIDs) > 0 {
+		slackUID = slackIDs[0]
+	}
+	return User{
+		Uuid:     user.Id,
+		Username: user.Username,
+		FullName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+		SlackUID: slackUID,
+	}
+}
+
+type keycloakGroup struct {
+	Id        string          `json:"id"`
+	Name      string          `json:"name"`
+	Path      string          `json:"path"`
+	SubGroups []keycloakGroup `json:"subGroups"`
+}
+
+// NewKeycloak creates a Keycloak client and starts refreshing its access token in the background
+func NewKeycloak(config KeycloakConfig) *Keycloak {
+	client := newKeycloak(config, &http.Client{Timeout: 10 * time.Second})
+	// this will async get the token
+	go client.refreshLoop()
+	return client
+}
+
+func newKeycloak(config KeycloakConfig, httpClient *http.Client) *Keycloak {
+	return &Keycloak{
+		config: config,
+		// the admin API for a realm lives at /admin/realms/{realm} alongside /realms/{realm}
+		adminBase:  strings.Replace(config.Issuer, "/realms/", "/admin/realms/", 1),
+		httpClient: httpClient,
+		groupCache: make(map[string]string),
+		quit:       make(chan struct{}),
+	}
+}
+
+// Close stops the background token refresher
+func (client *Keycloak) Close() {
+	client.quitOnce.Do(func() {
+		close(client.quit)
+	})
+}
+
+// refreshLoop keeps the access token fresh, backing off exponentially while Keycloak is unavailable
+func (client *Keycloak) refreshLoop() {
+	backoff := tokenMinBackoff
+	for {
+		wait := backoff
+		exp, err := client.getAccessToken()
+		if err != nil {
+			logging.Logger.WithFields(logrus.Fields{"module": "directory", "method": "refreshLoop", "retryIn": backoff}).Error(err)
+			backoff = min(backoff*2, tokenMaxBackoff)
+		} else {
+			backoff = tokenMinBackoff
+			wait = max(exp-tokenRefreshMargin, time.Second)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-client.quit:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// getAccessToken fetches a new access token and stores it, returning how long it is valid for
+func (client *Keycloak) getAccessToken() (time.Duration, error) {
+	client.refreshLock.Lock()
+	defer client.refreshLock.Unlock()
+	return client.fetchAccessToken()
+}
+
+// fetchAccessToken does the work of getAccessToken, refreshLock must be held
+func (client *Keycloak) fetchAccessToken() (_ time.Duration, err error) {
+	defer func() {
+		if err != nil {
+			metrics.DirectoryErrors.WithLabelValues("keycloak", "getAccessToken").Inc()
+		}
+	}()
+
+	//request body
+	authData := url.Values{}
+	authData.Set("client_id", client.config.ClientID)
+	authData.Set("client_secret", client.config.ClientSecret)
+	authData.Set("grant_type", "client_credentials")
+	resp, err := client.httpClient.PostForm(client.config.Issuer+"/protocol/openid-connect/token", authData)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, &OIDCError{Method: "getAccessToken", StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+	respData := struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+		Error       string `json:"error"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&respData)
+	if err != nil {
+		return 0, err
+	}
+	if respData.Error != "" || respData.AccessToken == "" {
+		return 0, errors.New("token endpoint returned an error: " + respData.Error)
+	}
+	expiresIn := time.Duration(respData.ExpiresIn) * time.Second
+	client.tokenLock.Lock()
+	client.accessToken = respData.AccessToken
+	client.tokenExpiry = time.Now().Add(expiresIn)
+	client.tokenLock.Unlock()
+	return expiresIn, nil
+}
+
+// CheckToken returns ErrNoAccessToken unless we hold an access token that hasn't expired
+func (client *Keycloak) CheckToken() error {
+	client.tokenLock.RLock()
+	defer client.tokenLock.RUnlock()
+	if client.accessToken == "" || time.Now().After(client.tokenExpiry) {
+		return ErrNoAccessToken
+	}
+	return nil
+}
+
+func (client *Keycloak) token() string {
+	client.tokenLock.RLock()
+	defer client.tokenLock.RUnlock()
+	return client.accessToken
+}
+
+// refreshToken fetches a new token unless another goroutine already replaced stale since we last read it
+//
+// The check is made while holding refreshLock, so goroutines that saw the same 401 wait for the first
+// one's refresh and then use its token rather than each fetching another
+func (client *Keycloak) refreshToken(stale string) error {
+	client.refreshLock.Lock()
+	defer client.refreshLock.Unlock()
+	if client.token() != stale {
+		return nil
+	}
+	_, err := client.fetchAccessToken()
+	return err
+}
+
+// get performs an authenticated GET against the Keycloak admin API for the realm, decoding the response into out
+//
+// Network errors and 5xx responses are retried with backoff, and a 401 causes the
+// access token to be refreshed and the request to be tried once more
+func (client *Keycloak) get(ctx context.Context, method string, path string, out any) (err error) {
+	defer func() {
+		// a missing user or group is an answer, not a failure to reach Keycloak
+		var oidcErr *OIDCError
+		if err != nil && !(errors.As(err, &oidcErr) && oidcErr.StatusCode == http.StatusNotFound) {
+			metrics.DirectoryErrors.WithLabelValues("keycloak", method).Inc()
+		}
+	}()
+	refreshed := false
+	backoff := requestBackoff
+	var lastErr error
+	for attempt := 0; attempt < requestAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+				backoff *= 2
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.adminBase+path, nil)
+		if err != nil {
+			return err
+		}
+		token := client.token()
+		req.Header.Add("Authorization", "Bearer "+token)
+		resp, err := client.httpClient.Do(req)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		switch {
+		case resp.StatusCode == http.StatusUnauthorized:
+			resp.Body.Close()
+			if refreshed {
+				return ErrOIDCUnauthorized
+			}
+			refreshed = true
+			if err := client.refreshToken(token); err != nil {
+				return fmt.Errorf("%w: %w", ErrOIDCUnauthorized, err)
+			}
+			// a refresh doesn't count against the retry budget
+			attempt--
+			continue
+		case resp.StatusCode >= http.StatusInternalServerError:
+			resp.Body.Close()
+			lastErr = &OIDCError{Method: method, StatusCode: resp.StatusCode, Status: resp.Status}
+			continue
+		case resp.StatusCode != http.StatusOK:
+			resp.Body.Close()
+			return &OIDCError{Method: method, StatusCode: resp.StatusCode, Status: resp.Status}
+		}
+		err = json.NewDecoder(resp.Body).Decode(out)
+		resp.Body.Close()
+		return err
+	}
+	return lastErr
+}
+
+// findGroup searches a group tree for a group with an exact name
+func findGroup(groups []keycloakGroup, name string) *keycloakGroup {
+	for i := range groups {
+		if groups[i].Name == name {
+			return &groups[i]
+		}
+		if found := findGroup(groups[i].SubGroups, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// FindGroupID resolves a group name to its ID, returning ErrGroupNotFound if it doesn't exist
+func (client *Keycloak) FindGroupID(ctx context.Context, name string) (string, error) {
+	client.groupLock.RLock()
+	gid, ok := client.groupCache[name]
+	client.groupLock.RUnlock()
+	if ok {
+		return gid, nil
+	}
+	ret := make([]keycloakGroup, 0)
+	if err := client.get(ctx, "FindGroupID", "/groups?exact=true&search="+url.QueryEscape(name), &ret); err != nil {
+		return "", err
+	}
+	//Example:
+	//[{"id":"47dd1a94-853c-426d-b181-6d0714074892","name":"eboard","path":"/eboard","subGroups":[{"id":"66b9578a-2b58-46a6-8040-59388e57e830","name":"eboard-opcomm","path":"/eboard/eboard-opcomm","subGroups":[]}]}]
+	//searching returns the matching group nested under all of its parents, so we dig for it
+	group := findGroup(ret, name)
+	if group == nil {
+		return "", fmt.Errorf("%w: %s", ErrGroupNotFound, name)
+	}
+	client.groupLock.Lock()
+	client.groupCache[name] = group.Id
+	client.groupLock.Unlock()
+	return group.Id, nil
+}
+
+func (client *Keycloak) GetGroupMembers(ctx context.Context, group string) ([]User, error) {
+	groupID, err := client.FindGroupID(ctx, group)
+	if err != nil {
+		return nil, err
+	}
+	// Keycloak pages group members, 100 at a time by default
+	users := make([]User, 0)
+	for first := 0; ; first += groupPageSize {
+		ret := make([]keycloakUser, 0)
+		path := fmt.Sprintf("/groups/%s/members?first=%d&max=%d", url.PathEscape(groupID), first, groupPageSize)
+		if err := client.get(ctx, "GetGroupMembers", path, &ret); err != nil {
+			return nil, err
+		}
+		for _, user := range ret {
+			users = append(users, user.toUser())
+		}
+		if len(ret) < groupPageSize {
+			return users, nil
+		}
+	}
+}
+
+func (client *Keycloak) GetUser(ctx context.Context, username string) (*User, error) {
+	ret := make([]keycloakUser, 0)
+	if err := client.get(ctx, "GetUser", "/users?exact=true&username="+url.QueryEscape(username), &ret); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	user := ret[0].toUser()
+	return &user, nil
+}
+
+func (client *Keycloak) GetUserGroups(ctx context.Context, username string) ([]string, error) {
+	user, err := client.GetUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]keycloakGroup, 0)
+	if err := client.get(ctx, "GetUserGroups", "/users/"+url.PathEscape(user.Uuid)+"/groups", &ret); err != nil {
+		return nil, err
+	}
+	groups := make([]string, 0, len(ret))
+	for _, group := range ret {
+		groups = append(groups, group.Name)
+	}
+	return groups, nil
+}
+
+func (client *Keycloak) GetSlackUID(ctx context.Context, username string) (string, error) {
+	user, err := client.GetUser(ctx, username)
+	if err != nil {
+		return "", err
+	}
+	return user.SlackUID, nil
+}
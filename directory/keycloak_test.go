@@ -0,0 +1,131 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestKeycloak points a client at a fake Keycloak without starting the background refresher
+func newTestKeycloak(t *testing.T, admin http.HandlerFunc) (*Keycloak, *atomic.Int32) {
+	tokensIssued := &atomic.Int32{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth/realms/csh/protocol/openid-connect/token", func(w http.ResponseWriter, r *http.Request) {
+		n := tokensIssued.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"access_token": fmt.Sprintf("token-%d", n), "expires_in": 300})
+	})
+	mux.HandleFunc("/auth/admin/realms/csh/", admin)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	client := newKeycloak(KeycloakConfig{Issuer: srv.URL + "/auth/realms/csh"}, srv.Client())
+	_, err := client.getAccessToken()
+	assert.NoError(t, err)
+	return client, tokensIssued
+}
+
+func TestKeycloakRefreshesTokenOnUnauthorized(t *testing.T) {
+	client, tokensIssued := newTestKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode([]keycloakUser{{Id: "uuid", Username: "someone"}})
+	})
+
+	user, err := client.GetUser(context.Background(), "someone")
+	assert.NoError(t, err)
+	assert.Equal(t, &User{Uuid: "uuid", Username: "someone"}, user)
+	assert.Equal(t, int32(2), tokensIssued.Load())
+}
+
+func TestKeycloakRefreshesTokenOnceForConcurrentUnauthorized(t *testing.T) {
+	const requests = 10
+	// hold every request's 401 until they have all been made with the first token
+	var arrived sync.WaitGroup
+	arrived.Add(requests)
+	client, tokensIssued := newTestKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "Bearer token-1" {
+			arrived.Done()
+			arrived.Wait()
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode([]keycloakUser{{Id: "uuid", Username: "someone"}})
+	})
+
+	var done sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		done.Add(1)
+		go func() {
+			defer done.Done()
+			_, err := client.GetUser(context.Background(), "someone")
+			assert.NoError(t, err)
+		}()
+	}
+	done.Wait()
+	assert.Equal(t, int32(2), tokensIssued.Load())
+}
+
+func TestKeycloakGivesUpAfterSecondUnauthorized(t *testing.T) {
+	client, tokensIssued := newTestKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+
+	_, err := client.GetUser(context.Background(), "someone")
+	assert.ErrorIs(t, err, ErrOIDCUnauthorized)
+	assert.Equal(t, int32(2), tokensIssued.Load())
+}
+
+func TestFindGroupID(t *testing.T) {
+	requests := &atomic.Int32{}
+	client, _ := newTestKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Query().Get("search") != "eboard-opcomm" {
+			w.Write([]byte("[]"))
+			return
+		}
+		w.Write([]byte(`[{"id":"eboard-id","name":"eboard","path":"/eboard","subGroups":[{"id":"opcomm-id","name":"eboard-opcomm","path":"/eboard/eboard-opcomm","subGroups":[]}]}]`))
+	})
+
+	gid, err := client.FindGroupID(context.Background(), "eboard-opcomm")
+	assert.NoError(t, err)
+	assert.Equal(t, "opcomm-id", gid)
+
+	// the second lookup should be served from the cache
+	gid, err = client.FindGroupID(context.Background(), "eboard-opcomm")
+	assert.NoError(t, err)
+	assert.Equal(t, "opcomm-id", gid)
+	assert.Equal(t, int32(1), requests.Load())
+
+	_, err = client.FindGroupID(context.Background(), "nonexistent")
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+}
+
+func TestGetUserNotFound(t *testing.T) {
+	client, _ := newTestKeycloak(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	})
+
+	_, err := client.GetUser(context.Background(), "nobody")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestCheckToken(t *testing.T) {
+	client := newKeycloak(KeycloakConfig{}, http.DefaultClient)
+	assert.ErrorIs(t, client.CheckToken(), ErrNoAccessToken)
+
+	client, _ = newTestKeycloak(t, func(w http.ResponseWriter, r *http.Request) {})
+	assert.NoError(t, client.CheckToken())
+
+	client.tokenExpiry = time.Now().Add(-time.Second)
+	assert.ErrorIs(t, client.CheckToken(), ErrNoAccessToken)
+}
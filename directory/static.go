@@ -0,0 +1,137 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StaticUser is a user as written in a static directory file
+type StaticUser struct {
+	Username string   `json:"username" yaml:"username"`
+	FullName string   `json:"name" yaml:"name"`
+	SlackUID string   `json:"slackuid" yaml:"slackuid"`
+	Groups   []string `json:"groups" yaml:"groups"`
+	Gatekeep bool     `json:"gatekeep" yaml:"gatekeep"`
+}
+
+type staticFile struct {
+	Users []StaticUser `json:"users" yaml:"users"`
+}
+
+// Static is a directory read from a YAML or JSON file, so vote can run without Keycloak or conditional
+type Static struct {
+	users []StaticUser
+}
+
+// LoadStatic reads a static directory, files ending in .json are parsed as JSON and anything else as YAML
+func LoadStatic(path string) (*Static, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file staticFile
+	if filepath.Ext(path) == ".json" {
+		err = json.Unmarshal(b, &file)
+	} else {
+		err = yaml.Unmarshal(b, &file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	seen := make(map[string]bool)
+	for _, user := range file.Users {
+		if user.Username == "" {
+			return nil, fmt.Errorf("parsing %s: every user needs a username", path)
+		}
+		if seen[user.Username] {
+			return nil, fmt.Errorf("parsing %s: duplicate user %s", path, user.Username)
+		}
+		seen[user.Username] = true
+	}
+	return &Static{users: file.Users}, nil
+}
+
+// Users returns every user in the directory, in file order
+func (static *Static) Users() []StaticUser {
+	return static.users
+}
+
+func (static *Static) find(username string) (*StaticUser, error) {
+	for i := range static.users {
+		if static.users[i].Username == username {
+			return &static.users[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+}
+
+func (user StaticUser) toUser() User {
+	return User{
+		Uuid:     user.Username,
+		Username: user.Username,
+		FullName: user.FullName,
+		SlackUID: user.SlackUID,
+	}
+}
+
+func (static *Static) GetUser(ctx context.Context, username string) (*User, error) {
+	user, err := static.find(username)
+	if err != nil {
+		return nil, err
+	}
+	found := user.toUser()
+	return &found, nil
+}
+
+func (static *Static) GetGroupMembers(ctx context.Context, group string) ([]User, error) {
+	members := make([]User, 0)
+	for _, user := range static.users {
+		if slices.Contains(user.Groups, group) {
+			members = append(members, user.toUser())
+		}
+	}
+	if len(members) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrGroupNotFound, group)
+	}
+	return members, nil
+}
+
+func (static *Static) GetUserGroups(ctx context.Context, username string) ([]string, error) {
+	user, err := static.find(username)
+	if err != nil {
+		return nil, err
+	}
+	return user.Groups, nil
+}
+
+func (static *Static) GetSlackUID(ctx context.Context, username string) (string, error) {
+	user, err := static.find(username)
+	if err != nil {
+		return "", err
+	}
+	return user.SlackUID, nil
+}
+
+func (static *Static) GetGatekeep(ctx context.Context, username string) (bool, error) {
+	user, err := static.find(username)
+	if err != nil {
+		return false, err
+	}
+	return user.Gatekeep, nil
+}
+
+func (static *Static) GetEligibleVoters(ctx context.Context) ([]string, error) {
+	voters := make([]string, 0)
+	for _, user := range static.users {
+		if user.Gatekeep {
+			voters = append(voters, user.Username)
+		}
+	}
+	return voters, nil
+}
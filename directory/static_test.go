@@ -0,0 +1,53 @@
+package directory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadStatic(t *testing.T) {
+	static, err := LoadStatic("../dev/directory.yaml")
+	assert.NoError(t, err)
+
+	user, err := static.GetUser(context.Background(), "evals")
+	assert.NoError(t, err)
+	assert.Equal(t, "Evals Director", user.FullName)
+
+	groups, err := static.GetUserGroups(context.Background(), "evals")
+	assert.NoError(t, err)
+	assert.Contains(t, groups, "eboard-evaluations")
+
+	voters, err := static.GetEligibleVoters(context.Background())
+	assert.NoError(t, err)
+	assert.NotContains(t, voters, "ineligible")
+
+	_, err = static.GetUser(context.Background(), "nobody")
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	_, err = static.GetGroupMembers(context.Background(), "nonexistent")
+	assert.ErrorIs(t, err, ErrGroupNotFound)
+}
+
+func TestLoadStaticJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "directory.json")
+	os.WriteFile(path, []byte(`{"users": [{"username": "a", "groups": ["active"]}, {"username": "b", "groups": ["active"]}]}`), 0o600)
+
+	static, err := LoadStatic(path)
+	assert.NoError(t, err)
+
+	members, err := static.GetGroupMembers(context.Background(), "active")
+	assert.NoError(t, err)
+	assert.Len(t, members, 2)
+}
+
+func TestLoadStaticRejectsDuplicates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "directory.yaml")
+	os.WriteFile(path, []byte("users:\n  - username: a\n  - username: a\n"), 0o600)
+
+	_, err := LoadStatic(path)
+	assert.Error(t, err)
+}
@@ -1,12 +1,13 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
 	"slices"
 	"strings"
 
+	"github.com/computersciencehouse/vote/logging"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 var votes map[string]float32
@@ -16,15 +17,11 @@ var OPTIONS = []string{"Pass", "Fail", "Abstain"}
 
 func HandleGetEboardVote(c *gin.Context) {
 	user := GetUserData(c)
-	if !IsEboard(user) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "You need to be E-Board to access this page"})
-		return
-	}
 	if votes == nil {
 		votes = make(map[string]float32)
 	}
-	fmt.Println(user)
-	c.HTML(http.StatusOK, "eboard.tmpl", gin.H{
+	logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "HandleGetEboardVote", "groups": user.Groups}).Debug("showing eboard vote")
+	render(c, http.StatusOK, "eboard.tmpl", gin.H{
 		"Username":  user.Username,
 		"EBoard":    IsEboard(user),
 		"Voted":     slices.Contains(voters, user.Username),
@@ -36,10 +33,6 @@ func HandleGetEboardVote(c *gin.Context) {
 
 func HandlePostEboardVote(c *gin.Context) {
 	user := GetUserData(c)
-	if !IsEboard(user) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "You need to be E-Board to access this page"})
-		return
-	}
 	if slices.Contains(voters, user.Username) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot vote again!"})
 		return
@@ -53,9 +46,17 @@ func HandlePostEboardVote(c *gin.Context) {
 	}
 	//get the eboard position, count the members, and divide one whole vote by the number of members in the position
 	position := user.Groups[i]
-	positionMembers := oidcClient.GetOIDCGroup(oidcClient.FindOIDCGroupID(position))
+	positionMembers, err := userDirectory.GetGroupMembers(c, position)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(positionMembers) == 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Your E-Board position has no members"})
+		return
+	}
 	weight := 1.0 / float32(len(positionMembers))
-	fmt.Println(weight)
+	logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "HandlePostEboardVote", "position": position, "weight": weight}).Debug("weighted eboard vote")
 	//post the vote
 	option := c.PostForm("option")
 	if option == "" {
@@ -67,11 +68,6 @@ func HandlePostEboardVote(c *gin.Context) {
 }
 
 func HandleManageEboardVote(c *gin.Context) {
-	user := GetUserData(c)
-	if !IsEboard(user) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "You need to be E-Board to access this page"})
-		return
-	}
 	if c.PostForm("clear_vote") != "" {
 		votes = make(map[string]float32)
 		voters = make([]string, 0)
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/directory"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// gatekeepEligibility builds eligibility entries for the users conditional reports as meeting gatekeep
+func gatekeepEligibility(voters []string, addedBy string) []database.EligibilityEntry {
+	now := time.Now()
+	entries := make([]database.EligibilityEntry, 0, len(voters))
+	for _, voter := range voters {
+		entries = append(entries, database.EligibilityEntry{
+			Username: voter,
+			Source:   database.ELIGIBILITY_GATEKEEP,
+			AddedBy:  addedBy,
+			AddedAt:  now,
+		})
+	}
+	return entries
+}
+
+// waivedEligibility builds eligibility entries for users waived into a gatekeep poll, validating each against the directory
+//
+// Blank entries are ignored, any username that does not exist results in an error listing all of them
+func waivedEligibility(ctx context.Context, usernames []string, addedBy string) ([]database.EligibilityEntry, error) {
+	now := time.Now()
+	entries := make([]database.EligibilityEntry, 0)
+	unknown := make([]string, 0)
+	for _, username := range usernames {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+		dirUser, err := userDirectory.GetUser(ctx, username)
+		if errors.Is(err, directory.ErrUserNotFound) {
+			unknown = append(unknown, username)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: unable to validate waived user %s: %w", errDirectoryUnavailable, username, err)
+		}
+		entries = append(entries, database.EligibilityEntry{
+			Username: dirUser.Username,
+			Source:   database.ELIGIBILITY_WAIVER,
+			AddedBy:  addedBy,
+			AddedAt:  now,
+		})
+	}
+	if len(unknown) > 0 {
+		return nil, userError("Unknown waived users: " + strings.Join(unknown, ", "))
+	}
+	return entries, nil
+}
+
+// audienceEligibility snapshots the members of the OIDC groups a poll is restricted to
+//
+// Returns the deduplicated group names along with an eligibility entry for every member,
+// any group that does not exist results in an error listing all of them
+func audienceEligibility(ctx context.Context, audience []string, addedBy string) ([]string, []database.EligibilityEntry, error) {
+	now := time.Now()
+	groups := make([]string, 0)
+	entries := make([]database.EligibilityEntry, 0)
+	unknown := make([]string, 0)
+	for _, group := range audience {
+		group = strings.TrimSpace(group)
+		if group == "" || slices.Contains(groups, group) {
+			continue
+		}
+		members, err := userDirectory.GetGroupMembers(ctx, group)
+		if errors.Is(err, directory.ErrGroupNotFound) {
+			unknown = append(unknown, group)
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: unable to get members of %s: %w", errDirectoryUnavailable, group, err)
+		}
+		groups = append(groups, group)
+		for _, member := range members {
+			entries = append(entries, database.EligibilityEntry{
+				Username: member.Username,
+				Source:   database.ELIGIBILITY_AUDIENCE,
+				AddedBy:  addedBy,
+				AddedAt:  now,
+			})
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, nil, userError("Unknown audience groups: " + strings.Join(unknown, ", "))
+	}
+	return groups, database.DedupeEligibility(entries), nil
+}
+
+// keptOnResnapshot reports whether an eligibility entry was added by hand, rather than snapshotted from
+// conditional, so re-snapshotting the poll doesn't drop it
+//
+// Legacy entries predate tracking where eligibility came from, so they are treated as part of the snapshot
+func keptOnResnapshot(entry database.EligibilityEntry) bool {
+	switch entry.Source {
+	case database.ELIGIBILITY_MANUAL, database.ELIGIBILITY_APPEAL, database.ELIGIBILITY_WAIVER:
+		return true
+	default:
+		return false
+	}
+}
+
+// resnapshotEligibility recomputes the snapshotted portion of a poll's eligibility from conditional
+//
+// Manual additions, appeals and waivers are kept, as is anyone who has already voted so their ballot still counts towards quorum
+func resnapshotEligibility(ctx context.Context, entries []database.EligibilityEntry, voters []string, addedBy string, hasVoted func(username string) (bool, error)) []database.EligibilityEntry {
+	resnapshot := gatekeepEligibility(voters, addedBy)
+	for _, entry := range entries {
+		if keptOnResnapshot(entry) {
+			resnapshot = append(resnapshot, entry)
+			continue
+		}
+		voted, err := hasVoted(entry.Username)
+		if err != nil {
+			logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "resnapshotEligibility"}).Error(err)
+			continue
+		}
+		if voted {
+			resnapshot = append(resnapshot, entry)
+		}
+	}
+	return database.DedupeEligibility(resnapshot)
+}
+
+// How many times a re-snapshot is retried when someone else changes the poll's eligibility at the same time
+const resnapshotAttempts = 3
+
+// ResnapshotEligibility Refetches the gatekeep list from conditional and replaces the gatekeep eligibility of a poll
+func ResnapshotEligibility(c *gin.Context) {
+	user := GetUserData(c)
+
+	poll := GetPollData(c)
+
+	if !poll.Gatekeep || !poll.Open {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Eligibility can only be re-snapshot for open gatekeep polls"})
+		return
+	}
+
+	voters, err := userDirectory.GetEligibleVoters(c)
+	if err != nil {
+		logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "ResnapshotEligibility"}).Error(err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Unable to get eligible voters from conditional: " + err.Error()})
+		return
+	}
+
+	hasVoted := func(username string) (bool, error) {
+		return database.HasVoted(c, poll.Id, username)
+	}
+	before := poll.AllowedUsers
+	for attempt := 1; ; attempt++ {
+		err = poll.SetEligibility(c, resnapshotEligibility(c, poll.EligibilityEntries(), voters, user.Username, hasVoted))
+		if !errors.Is(err, database.ErrEligibilityChanged) || attempt == resnapshotAttempts {
+			break
+		}
+		poll, err = database.GetPoll(c, poll.Id)
+		if err != nil {
+			break
+		}
+		// diff against what this attempt replaced, not what the first one read
+		before = poll.AllowedUsers
+	}
+	if errors.Is(err, database.ErrEligibilityChanged) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The poll's eligibility kept changing, try again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	added, removed := diffUsers(before, poll.AllowedUsers)
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	action := database.Action{
+		Id:     "",
+		PollId: pId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: fmt.Sprintf("Re-snapshot Eligibility (added: %s; removed: %s)", strings.Join(added, ", "), strings.Join(removed, ", ")),
+	}
+	err = database.WriteAction(c, &action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/results/"+poll.Id)
+}
+
+// AddEligibleUser Manually allows a user to vote in a gatekeep poll
+func AddEligibleUser(c *gin.Context) {
+	user := GetUserData(c)
+
+	poll := GetPollData(c)
+
+	if !poll.Gatekeep || !poll.Open {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Eligibility can only be changed for open gatekeep polls"})
+		return
+	}
+
+	username := strings.TrimSpace(c.PostForm("username"))
+	if username == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You need to provide a username"})
+		return
+	}
+	dirUser, err := userDirectory.GetUser(c, username)
+	if errors.Is(err, directory.ErrUserNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown user: " + username})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	added, err := poll.AddEligibility(c, database.EligibilityEntry{
+		Username: dirUser.Username,
+		Source:   database.ELIGIBILITY_MANUAL,
+		AddedBy:  user.Username,
+		AddedAt:  time.Now(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !added {
+		c.JSON(http.StatusBadRequest, gin.H{"error": dirUser.Username + " is already eligible to vote in this poll"})
+		return
+	}
+
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	action := database.Action{
+		Id:     "",
+		PollId: pId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: "Add Eligible Voter: " + dirUser.Username,
+	}
+	err = database.WriteAction(c, &action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/results/"+poll.Id)
+}
+
+// diffUsers returns the users present only in after, and those present only in before
+func diffUsers(before, after []string) ([]string, []string) {
+	inBefore := make(map[string]bool)
+	for _, u := range before {
+		inBefore[u] = true
+	}
+	inAfter := make(map[string]bool)
+	added := make([]string, 0)
+	for _, u := range after {
+		inAfter[u] = true
+		if !inBefore[u] {
+			added = append(added, u)
+		}
+	}
+	removed := make([]string, 0)
+	for _, u := range before {
+		if !inAfter[u] {
+			removed = append(removed, u)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/directory"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudienceEligibility(t *testing.T) {
+	static, err := directory.LoadStatic("dev/directory.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userDirectory = static
+
+	tests := []struct {
+		name     string
+		audience []string
+		groups   []string
+		users    []string
+		err      error
+	}{
+		{
+			name:     "single group",
+			audience: []string{"eboard"},
+			groups:   []string{"eboard"},
+			users:    []string{"eboard", "evals"},
+		},
+		{
+			name:     "overlapping groups are deduplicated",
+			audience: []string{" eboard-opcomm", "active-rtp", "eboard", "", "eboard "},
+			groups:   []string{"eboard-opcomm", "active-rtp", "eboard"},
+			users:    []string{"eboard", "rtp", "evals"},
+		},
+		{
+			name:     "unknown groups",
+			audience: []string{"eboard", "freshmen", "floor-1"},
+			err:      userError("Unknown audience groups: freshmen, floor-1"),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			groups, entries, err := audienceEligibility(context.Background(), test.audience, "creator")
+			if test.err != nil {
+				assert.Equal(t, test.err, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.groups, groups)
+			assert.ElementsMatch(t, test.users, database.EligibleUsernames(entries))
+			for _, entry := range entries {
+				assert.Equal(t, database.ELIGIBILITY_AUDIENCE, entry.Source)
+				assert.Equal(t, "creator", entry.AddedBy)
+			}
+		})
+	}
+}
+
+func TestResnapshotEligibility(t *testing.T) {
+	entry := func(username, source string) database.EligibilityEntry {
+		return database.EligibilityEntry{Username: username, Source: source, AddedBy: "evals"}
+	}
+	voted := map[string]bool{"voted": true, "legacy-voted": true}
+	hasVoted := func(username string) (bool, error) {
+		return voted[username], nil
+	}
+
+	tests := []struct {
+		name    string
+		entries []database.EligibilityEntry
+		voters  []string
+		users   []string
+		sources map[string]string
+	}{
+		{
+			name:    "new gatekeep list replaces the old one",
+			entries: []database.EligibilityEntry{entry("old", database.ELIGIBILITY_GATEKEEP), entry("still", database.ELIGIBILITY_GATEKEEP)},
+			voters:  []string{"still", "new"},
+			users:   []string{"still", "new"},
+		},
+		{
+			name: "manual additions, appeals and waivers are kept",
+			entries: []database.EligibilityEntry{
+				entry("manual", database.ELIGIBILITY_MANUAL),
+				entry("appeal", database.ELIGIBILITY_APPEAL),
+				entry("waiver", database.ELIGIBILITY_WAIVER),
+				entry("old", database.ELIGIBILITY_GATEKEEP),
+			},
+			voters: []string{"new"},
+			users:  []string{"new", "manual", "appeal", "waiver"},
+			sources: map[string]string{
+				"manual": database.ELIGIBILITY_MANUAL,
+				"appeal": database.ELIGIBILITY_APPEAL,
+				"waiver": database.ELIGIBILITY_WAIVER,
+			},
+		},
+		{
+			name:    "legacy entries are recomputed",
+			entries: []database.EligibilityEntry{entry("legacy", database.ELIGIBILITY_LEGACY), entry("legacy-gatekept", database.ELIGIBILITY_LEGACY)},
+			voters:  []string{"legacy-gatekept"},
+			users:   []string{"legacy-gatekept"},
+			sources: map[string]string{"legacy-gatekept": database.ELIGIBILITY_GATEKEEP},
+		},
+		{
+			name:    "users who already voted are kept",
+			entries: []database.EligibilityEntry{entry("voted", database.ELIGIBILITY_GATEKEEP), entry("legacy-voted", database.ELIGIBILITY_LEGACY)},
+			voters:  []string{"new"},
+			users:   []string{"new", "voted", "legacy-voted"},
+			sources: map[string]string{"voted": database.ELIGIBILITY_GATEKEEP, "legacy-voted": database.ELIGIBILITY_LEGACY},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries := resnapshotEligibility(context.Background(), test.entries, test.voters, "evals", hasVoted)
+			assert.ElementsMatch(t, test.users, database.EligibleUsernames(entries))
+			for _, entry := range entries {
+				source, ok := test.sources[entry.Username]
+				if !ok {
+					source = database.ELIGIBILITY_GATEKEEP
+				}
+				assert.Equal(t, source, entry.Source, entry.Username)
+			}
+		})
+	}
+}
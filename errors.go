@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/computersciencehouse/vote/database"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// userError is a problem with what the user submitted, which they can fix and try again
+type userError string
+
+func (err userError) Error() string {
+	return string(err)
+}
+
+var (
+	errPollNotFound         = errors.New("Poll not found")
+	errAlreadyVoted         = errors.New("You have already voted in this poll")
+	errDirectoryUnavailable = errors.New("Unable to reach the member directory")
+)
+
+// loadPoll Retreives a poll, returning errPollNotFound if it doesn't exist
+func loadPoll(ctx context.Context, id string) (*database.Poll, error) {
+	poll, err := database.GetPoll(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, errPollNotFound
+	}
+	return poll, err
+}
+
+// errorStatus picks the HTTP status to respond with for an error
+func errorStatus(err error) int {
+	var invalid userError
+	var denied denial
+	switch {
+	case errors.As(err, &invalid):
+		return http.StatusBadRequest
+	case errors.Is(err, errInvalidToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, errPollNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errAlreadyVoted):
+		return http.StatusConflict
+	case errors.As(err, &denied):
+		return http.StatusForbidden
+	case errors.Is(err, errDirectoryUnavailable):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
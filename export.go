@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ResultsExport is everything about the results of a poll that gets exported for the records
+type ResultsExport struct {
+	APIResults
+	Title         string  `json:"title"`
+	Description   string  `json:"description"`
+	Gatekeep      bool    `json:"gatekeep"`
+	QuorumPercent float64 `json:"quorumPercent,omitempty"`
+	// Only set for polls with a quorum
+	QuorumMet *bool `json:"quorumMet,omitempty"`
+	// Percentage of eligible voters who voted, only set for polls limited to gatekeep or an audience
+	Turnout *float64 `json:"turnout,omitempty"`
+	// The option(s) with the most votes, more than one means a tie
+	Winners []string `json:"winners"`
+	Tie     bool     `json:"tie"`
+	// Only set once the result has been certified
+	CertifiedAt *time.Time `json:"certifiedAt,omitempty"`
+	BallotHash  string     `json:"ballotHash,omitempty"`
+	ExportedAt  time.Time  `json:"exportedAt"`
+}
+
+// pollOutcome Works out which options won a poll from its results
+//
+// Simple polls are won by the option with the most votes, not counting abstentions.
+// Ranked polls are won by the option with the most votes in the final round
+func pollOutcome(poll *database.Poll, results []map[string]int) []string {
+	if len(results) == 0 {
+		return []string{}
+	}
+	round := results[len(results)-1]
+
+	winners := []string{}
+	most := 0
+	for option, count := range round {
+		if poll.VoteType == database.POLL_TYPE_SIMPLE && option == "Abstain" {
+			continue
+		}
+		if count == 0 || count < most {
+			continue
+		}
+		if count > most {
+			most = count
+			winners = winners[:0]
+		}
+		winners = append(winners, option)
+	}
+	sort.Strings(winners)
+	return winners
+}
+
+// loadResultsExport Gets the official results of a poll ready to export, including its certification if it has one
+func loadResultsExport(ctx context.Context, poll *database.Poll) (ResultsExport, []map[string]int, error) {
+	results, err := poll.GetOfficialResult(ctx)
+	if err != nil {
+		return ResultsExport{}, nil, err
+	}
+	export := toResultsExport(poll, results)
+	if poll.Open {
+		return export, results, nil
+	}
+	certified, err := poll.GetCertifiedResult(ctx)
+	if errors.Is(err, database.ErrNotCertified) {
+		return export, results, nil
+	}
+	if err != nil {
+		return ResultsExport{}, nil, err
+	}
+	export.CertifiedAt = &certified.CertifiedAt
+	export.BallotHash = certified.BallotHash
+	return export, results, nil
+}
+
+func toResultsExport(poll *database.Poll, results []map[string]int) ResultsExport {
+	export := ResultsExport{
+		APIResults:  toAPIResults(poll, results),
+		Title:       poll.Title,
+		Description: poll.Description,
+		Gatekeep:    poll.Gatekeep,
+		Winners:     pollOutcome(poll, results),
+		ExportedAt:  time.Now(),
+	}
+	export.Tie = len(export.Winners) > 1
+	if poll.Gatekeep {
+		export.QuorumPercent = poll.QuorumType * 100
+		quorumMet := export.NumVotes >= export.VotesNeededForQuorum
+		export.QuorumMet = &quorumMet
+	}
+	if export.EligibleVoters > 0 {
+		turnout := float64(export.NumVotes) / float64(export.EligibleVoters) * 100
+		export.Turnout = &turnout
+	}
+	return export
+}
+
+// exportOptions Lists every option that appears in any round, in the order they were offered, then write-ins alphabetically
+func exportOptions(poll *database.Poll, results []map[string]int) []string {
+	options := slices.Clone(poll.Options)
+	writeIns := []string{}
+	for _, round := range results {
+		for option := range round {
+			if !slices.Contains(options, option) && !slices.Contains(writeIns, option) {
+				writeIns = append(writeIns, option)
+			}
+		}
+	}
+	sort.Strings(writeIns)
+	return append(options, writeIns...)
+}
+
+// writeResultsCSV Writes a summary of the results followed by a table of votes per option in every round
+//
+// Options that were eliminated or not on the ballot in a round have an empty cell for it
+func writeResultsCSV(w io.Writer, poll *database.Poll, results []map[string]int, export ResultsExport) error {
+	out := csv.NewWriter(w)
+
+	optional := func(value *float64) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*value, 'f', 2, 64)
+	}
+	quorumMet := ""
+	if export.QuorumMet != nil {
+		quorumMet = strconv.FormatBool(*export.QuorumMet)
+	}
+	certifiedAt := ""
+	if export.CertifiedAt != nil {
+		certifiedAt = export.CertifiedAt.Format(time.RFC3339)
+	}
+	summary := [][]string{
+		{"Poll", export.Title},
+		{"Poll ID", export.PollId},
+		{"Vote Type", export.VoteType},
+		{"Open", strconv.FormatBool(export.Open)},
+		{"Votes Cast", strconv.Itoa(export.NumVotes)},
+		{"Eligible Voters", strconv.Itoa(export.EligibleVoters)},
+		{"Turnout %", optional(export.Turnout)},
+		{"Quorum %", strconv.FormatFloat(export.QuorumPercent, 'f', 0, 64)},
+		{"Votes Needed For Quorum", strconv.Itoa(export.VotesNeededForQuorum)},
+		{"Quorum Met", quorumMet},
+		{"Winner", strings.Join(export.Winners, "; ")},
+		{"Tie", strconv.FormatBool(export.Tie)},
+		{"Certified At", certifiedAt},
+		{"Ballot Hash", export.BallotHash},
+		{"Exported At", export.ExportedAt.Format(time.RFC3339)},
+		{},
+	}
+	for _, row := range summary {
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+
+	header := []string{"Option"}
+	for i := range results {
+		header = append(header, fmt.Sprintf("Round %d", i+1))
+	}
+	if err := out.Write(header); err != nil {
+		return err
+	}
+	for _, option := range exportOptions(poll, results) {
+		row := []string{option}
+		for _, round := range results {
+			count, ok := round[option]
+			if ok {
+				row = append(row, strconv.Itoa(count))
+			} else {
+				row = append(row, "")
+			}
+		}
+		if err := out.Write(row); err != nil {
+			return err
+		}
+	}
+
+	out.Flush()
+	return out.Error()
+}
+
+// ExportResultsJSON Downloads the results of a poll as JSON
+func ExportResultsJSON(c *gin.Context) {
+	poll := GetPollData(c)
+
+	export, _, err := loadResultsExport(c, poll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="poll-%s-results.json"`, poll.Id))
+	c.IndentedJSON(http.StatusOK, export)
+}
+
+// ExportResultsCSV Downloads the results of a poll as CSV
+func ExportResultsCSV(c *gin.Context) {
+	poll := GetPollData(c)
+
+	export, results, err := loadResultsExport(c, poll)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var out strings.Builder
+	if err := writeResultsCSV(&out, poll, results, export); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="poll-%s-results.csv"`, poll.Id))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", []byte(out.String()))
+}
+
+// bltName Quotes a candidate or title for a BLT file, which has no way to escape quotes
+func bltName(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, "'") + `"`
+}
+
+// writeBLT Writes ranked ballots in the BLT format used by OpenSTV, the ERS and most other STV/IRV counting tools
+//
+// Each ballot is a list of candidates from first preference to last, and the election fills one seat
+func writeBLT(w io.Writer, title string, candidates []string, ballots [][]string) error {
+	index := make(map[string]int, len(candidates))
+	for i, candidate := range candidates {
+		index[candidate] = i + 1
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%d 1\n", len(candidates))
+	for _, ballot := range ballots {
+		out.WriteString("1")
+		for _, candidate := range ballot {
+			i, ok := index[candidate]
+			if !ok {
+				return fmt.Errorf("ballot ranks unknown candidate %s", candidate)
+			}
+			fmt.Fprintf(&out, " %d", i)
+		}
+		out.WriteString(" 0\n")
+	}
+	out.WriteString("0\n")
+	for _, candidate := range candidates {
+		out.WriteString(bltName(candidate) + "\n")
+	}
+	out.WriteString(bltName(title) + "\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// writeBallotsCSV Writes simple ballots as a CSV with one row per ballot
+func writeBallotsCSV(w io.Writer, ballots []string) error {
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"Ballot", "Option"}); err != nil {
+		return err
+	}
+	for i, option := range ballots {
+		if err := out.Write([]string{strconv.Itoa(i + 1), option}); err != nil {
+			return err
+		}
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// ballotCandidates Lists the options on the ballot followed by any write-ins alphabetically
+func ballotCandidates(poll *database.Poll, ballots [][]string) []string {
+	candidates := slices.Clone(poll.Options)
+	writeIns := []string{}
+	for _, ballot := range ballots {
+		for _, candidate := range ballot {
+			if !slices.Contains(candidates, candidate) && !slices.Contains(writeIns, candidate) {
+				writeIns = append(writeIns, candidate)
+			}
+		}
+	}
+	sort.Strings(writeIns)
+	return append(candidates, writeIns...)
+}
+
+// ExportBallots Downloads the anonymized ballots of a closed poll so anyone can recount it
+//
+// Ranked polls are exported as BLT and simple polls as CSV. Only the choices on each ballot
+// are included, and ballots are shuffled so their order can't be matched up with who voted when
+func ExportBallots(c *gin.Context) {
+	user := GetUserData(c)
+	poll := GetPollData(c)
+
+	var body strings.Builder
+	var filename, contentType string
+	switch poll.VoteType {
+	case database.POLL_TYPE_SIMPLE:
+		votes, err := database.GetSimpleVotes(c, poll.Id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		ballots := make([]string, 0, len(votes))
+		for _, vote := range votes {
+			ballots = append(ballots, vote.Option)
+		}
+		rand.Shuffle(len(ballots), func(i, j int) {
+			ballots[i], ballots[j] = ballots[j], ballots[i]
+		})
+		if err := writeBallotsCSV(&body, ballots); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		filename = fmt.Sprintf("poll-%s-ballots.csv", poll.Id)
+		contentType = "text/csv; charset=utf-8"
+	case database.POLL_TYPE_RANKED:
+		votes, err := database.GetRankedVotes(c, poll.Id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		ballots := make([][]string, 0, len(votes))
+		for _, vote := range votes {
+			ballots = append(ballots, vote.Preferences())
+		}
+		rand.Shuffle(len(ballots), func(i, j int) {
+			ballots[i], ballots[j] = ballots[j], ballots[i]
+		})
+		if err := writeBLT(&body, poll.Title, ballotCandidates(poll, ballots), ballots); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		filename = fmt.Sprintf("poll-%s-ballots.blt", poll.Id)
+		contentType = "text/plain; charset=utf-8"
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Unknown Poll Type"})
+		return
+	}
+
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	action := database.Action{
+		Id:     "",
+		PollId: pId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: "Export Ballots",
+	}
+	if err := database.WriteAction(c, &action); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, contentType, []byte(body.String()))
+}
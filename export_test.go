@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollOutcome(t *testing.T) {
+	simple := &database.Poll{VoteType: database.POLL_TYPE_SIMPLE, Options: []string{"Pass", "Fail", "Abstain"}}
+	ranked := &database.Poll{VoteType: database.POLL_TYPE_RANKED, Options: []string{"Alice", "Bob", "Carol"}}
+
+	tests := []struct {
+		name    string
+		poll    *database.Poll
+		results []map[string]int
+		winners []string
+	}{
+		{name: "simple", poll: simple, results: []map[string]int{{"Pass": 5, "Fail": 2, "Abstain": 1}}, winners: []string{"Pass"}},
+		{name: "simple abstain ignored", poll: simple, results: []map[string]int{{"Pass": 2, "Fail": 1, "Abstain": 9}}, winners: []string{"Pass"}},
+		{name: "simple tie", poll: simple, results: []map[string]int{{"Pass": 3, "Fail": 3, "Abstain": 0}}, winners: []string{"Fail", "Pass"}},
+		{name: "simple no votes", poll: simple, results: []map[string]int{{"Pass": 0, "Fail": 0, "Abstain": 0}}, winners: []string{}},
+		{name: "ranked majority", poll: ranked, results: []map[string]int{{"Alice": 3, "Bob": 2, "Carol": 1}, {"Alice": 4, "Bob": 2}, {"Alice": 4}}, winners: []string{"Alice"}},
+		{name: "ranked tie", poll: ranked, results: []map[string]int{{"Alice": 2, "Bob": 2}}, winners: []string{"Alice", "Bob"}},
+		{name: "ranked abstain counts", poll: &database.Poll{VoteType: database.POLL_TYPE_RANKED}, results: []map[string]int{{"Abstain": 2}}, winners: []string{"Abstain"}},
+		{name: "no results", poll: ranked, results: []map[string]int{}, winners: []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.winners, pollOutcome(test.poll, test.results))
+		})
+	}
+}
+
+func TestToResultsExport(t *testing.T) {
+	poll := &database.Poll{
+		Id:           "1",
+		VoteType:     database.POLL_TYPE_SIMPLE,
+		Options:      []string{"Pass", "Fail", "Abstain"},
+		Gatekeep:     true,
+		QuorumType:   0.5,
+		AllowedUsers: []string{"a", "b", "c", "d"},
+	}
+	export := toResultsExport(poll, []map[string]int{{"Pass": 1, "Fail": 0, "Abstain": 0}})
+	assert.Equal(t, 1, export.NumVotes)
+	assert.Equal(t, 4, export.EligibleVoters)
+	assert.Equal(t, 25.0, *export.Turnout)
+	assert.Equal(t, 50.0, export.QuorumPercent)
+	assert.False(t, *export.QuorumMet)
+	assert.False(t, export.Tie)
+
+	open := toResultsExport(&database.Poll{VoteType: database.POLL_TYPE_SIMPLE}, []map[string]int{{"Pass": 1}})
+	assert.Nil(t, open.QuorumMet)
+	assert.Nil(t, open.Turnout)
+}
+
+func TestWriteResultsCSV(t *testing.T) {
+	poll := &database.Poll{Id: "1", Title: "President", VoteType: database.POLL_TYPE_RANKED, Options: []string{"Bob", "Alice"}}
+	results := []map[string]int{{"Alice": 2, "Bob": 2, "Write, In": 1}, {"Alice": 3, "Bob": 2}, {"Alice": 3}}
+
+	var out strings.Builder
+	err := writeResultsCSV(&out, poll, results, toResultsExport(poll, results))
+	assert.NoError(t, err)
+	csv := out.String()
+	assert.Contains(t, csv, "Poll,President\n")
+	assert.Contains(t, csv, "Winner,Alice\n")
+	assert.Contains(t, csv, "Option,Round 1,Round 2,Round 3\nBob,2,2,\nAlice,2,3,3\n\"Write, In\",1,,\n")
+}
+
+func TestWriteBLT(t *testing.T) {
+	poll := &database.Poll{Title: `The "Best" Pizza`, Options: []string{"Cheese", "Pepperoni"}}
+	ballots := [][]string{{"Pepperoni", "Cheese"}, {"Cheese"}, {"Mushroom", "Cheese", "Pepperoni"}}
+	candidates := ballotCandidates(poll, ballots)
+	assert.Equal(t, []string{"Cheese", "Pepperoni", "Mushroom"}, candidates)
+
+	var out strings.Builder
+	err := writeBLT(&out, poll.Title, candidates, ballots)
+	assert.NoError(t, err)
+	assert.Equal(t, "3 1\n1 2 1 0\n1 1 0\n1 3 1 2 0\n0\n\"Cheese\"\n\"Pepperoni\"\n\"Mushroom\"\n\"The 'Best' Pizza\"\n", out.String())
+
+	err = writeBLT(&out, poll.Title, []string{"Cheese"}, ballots)
+	assert.Error(t, err)
+}
+
+func TestWriteBallotsCSV(t *testing.T) {
+	var out strings.Builder
+	err := writeBallotsCSV(&out, []string{"Pass", "Fail, with notes"})
+	assert.NoError(t, err)
+	assert.Equal(t, "Ballot,Option\n1,Pass\n2,\"Fail, with notes\"\n", out.String())
+}
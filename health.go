@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// How long each readiness check gets before it counts as failed
+	healthCheckTimeout = 3 * time.Second
+	// Readiness results are reused for this long, so frequent probes don't hammer our dependencies
+	readinessCacheTTL = 10 * time.Second
+	// Conditional can only be checked by building the whole gatekeep list, so it's checked far less often
+	conditionalCheckTTL = 5 * time.Minute
+)
+
+// healthCheck is one dependency checked by /readyz
+//
+// Only critical checks make us unready, the others are reported so we can see what's degraded,
+// but every instance depends on them equally so taking instances out of rotation wouldn't help
+type healthCheck struct {
+	Name     string
+	Critical bool
+	Check    func(ctx context.Context) error
+}
+
+// Statuses of a single check, why a check failed is only logged since /readyz is public
+const (
+	checkOk   = "ok"
+	checkFail = "fail"
+)
+
+type readiness struct {
+	Status string `json:"status"`
+	// Checks maps each check to checkOk or checkFail
+	Checks map[string]string `json:"checks"`
+}
+
+var (
+	readinessLock    sync.Mutex
+	readinessCache   *readiness
+	readinessChecked time.Time
+)
+
+// cachedCheck reuses the last result of a check that is expensive for the dependency to answer
+type cachedCheck struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	checked time.Time
+	err     error
+}
+
+var conditionalCheck = &cachedCheck{ttl: conditionalCheckTTL}
+
+// run Runs check if the last result is older than the TTL, otherwise returns the last result
+func (cache *cachedCheck) run(ctx context.Context, check func(ctx context.Context) error) error {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	if cache.checked.IsZero() || time.Since(cache.checked) > cache.ttl {
+		cache.err = check(ctx)
+		cache.checked = time.Now()
+	}
+	return cache.err
+}
+
+// healthChecks Lists the dependencies that are checked for readiness
+func healthChecks() []healthCheck {
+	checks := []healthCheck{
+		{Name: "mongo", Critical: true, Check: database.Ping},
+		{Name: "evaluator", Critical: true, Check: func(ctx context.Context) error {
+			if !evaluatorAlive.Load() {
+				return errors.New("poll evaluation is not running")
+			}
+			return nil
+		}},
+		// Only the bot token is checked, the socket mode connection is never opened since we only send messages
+		{Name: "slack-token", Check: func(ctx context.Context) error {
+			if slackData.Client == nil {
+				return errors.New("slack is not configured")
+			}
+			_, err := slackData.Client.AuthTestContext(ctx)
+			return err
+		}},
+	}
+	if keycloak, ok := userDirectory.(interface{ CheckToken() error }); ok {
+		checks = append(checks, healthCheck{Name: "oidc", Check: func(ctx context.Context) error {
+			return keycloak.CheckToken()
+		}})
+	}
+	if conditional, ok := userDirectory.(interface {
+		CheckReachable(ctx context.Context) error
+	}); ok {
+		checks = append(checks, healthCheck{Name: "conditional", Check: func(ctx context.Context) error {
+			return conditionalCheck.run(ctx, conditional.CheckReachable)
+		}})
+	}
+	return checks
+}
+
+// runHealthChecks Runs every check at once and works out whether we're ready
+func runHealthChecks(ctx context.Context, checks []healthCheck) *readiness {
+	results := make([]string, len(checks))
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Check(checkCtx)
+			results[i] = checkOk
+			if err != nil {
+				results[i] = checkFail
+				logging.Logger.WithContext(ctx).WithFields(logrus.Fields{
+					"method":    "runHealthChecks",
+					"check":     check.Name,
+					"critical":  check.Critical,
+					"latencyMs": time.Since(start).Milliseconds(),
+				}).Warn(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	ready := &readiness{Status: "ok", Checks: make(map[string]string, len(checks))}
+	for i, check := range checks {
+		ready.Checks[check.Name] = results[i]
+		if results[i] == checkOk {
+			continue
+		}
+		if check.Critical {
+			ready.Status = "unavailable"
+		} else if ready.Status == "ok" {
+			ready.Status = "degraded"
+		}
+	}
+	return ready
+}
+
+// GetHealth Tells the orchestrator the process is alive
+func GetHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// GetReadiness Reports whether each dependency is up, failing if a critical one is down
+func GetReadiness(c *gin.Context) {
+	readinessLock.Lock()
+	if readinessCache == nil || time.Since(readinessChecked) > readinessCacheTTL {
+		readinessCache = runHealthChecks(c, healthChecks())
+		readinessChecked = time.Now()
+	}
+	ready := readinessCache
+	readinessLock.Unlock()
+
+	status := http.StatusOK
+	if ready.Status == "unavailable" {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, ready)
+}
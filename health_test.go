@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunHealthChecks(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	fail := func(ctx context.Context) error { return errors.New("down") }
+
+	tests := []struct {
+		name   string
+		checks []healthCheck
+		status string
+	}{
+		{name: "all ok", checks: []healthCheck{{Name: "mongo", Critical: true, Check: ok}, {Name: "slack-token", Check: ok}}, status: "ok"},
+		{name: "optional down", checks: []healthCheck{{Name: "mongo", Critical: true, Check: ok}, {Name: "slack-token", Check: fail}}, status: "degraded"},
+		{name: "critical down", checks: []healthCheck{{Name: "mongo", Critical: true, Check: fail}, {Name: "slack-token", Check: fail}}, status: "unavailable"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ready := runHealthChecks(context.Background(), test.checks)
+			assert.Equal(t, test.status, ready.Status)
+			assert.Len(t, ready.Checks, len(test.checks))
+		})
+	}
+
+	// the reason is only logged, since anyone can see /readyz
+	ready := runHealthChecks(context.Background(), []healthCheck{{Name: "conditional", Check: fail}, {Name: "oidc", Check: ok}})
+	assert.Equal(t, map[string]string{"conditional": checkFail, "oidc": checkOk}, ready.Checks)
+}
+
+func TestCachedCheck(t *testing.T) {
+	calls := 0
+	check := func(ctx context.Context) error {
+		calls++
+		return errors.New("down")
+	}
+
+	cache := &cachedCheck{ttl: time.Hour}
+	assert.Error(t, cache.run(context.Background(), check))
+	// the failure is reused too, so a struggling dependency isn't asked again every probe
+	assert.Error(t, cache.run(context.Background(), check))
+	assert.Equal(t, 1, calls)
+
+	cache.checked = time.Now().Add(-2 * time.Hour)
+	cache.run(context.Background(), check)
+	assert.Equal(t, 2, calls)
+}
@@ -1,8 +1,10 @@
 package logging
 
 import (
+	"fmt"
 	"os"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
@@ -11,12 +13,11 @@ import (
 var Logger *logrus.Logger = makeLogger()
 
 func makeLogger() *logrus.Logger {
-	// TODO should this someday be configurable?
 	level := logrus.InfoLevel
 	if testing.Testing() {
 		level = logrus.DebugLevel
 	}
-	return &logrus.Logger{
+	logger := &logrus.Logger{
 		Out: os.Stdout,
 		Formatter: &logrus.TextFormatter{
 			DisableLevelTruncation: true,
@@ -26,6 +27,37 @@ func makeLogger() *logrus.Logger {
 		Hooks: make(logrus.LevelHooks),
 		Level: level,
 	}
+	logger.AddHook(contextHook{})
+	return logger
+}
+
+// Configure Sets the log level (eg debug, info, warn) and format (text or json), empty values keep the defaults
+func Configure(level string, format string) error {
+	return configure(Logger, level, format)
+}
+
+// configure Applies a log level and format, leaving the defaults in place for anything that's empty or invalid
+func configure(logger *logrus.Logger, level string, format string) error {
+	var errs []string
+	if level != "" {
+		parsed, err := logrus.ParseLevel(level)
+		if err != nil {
+			errs = append(errs, err.Error())
+		} else {
+			logger.SetLevel(parsed)
+		}
+	}
+	switch strings.ToLower(format) {
+	case "", "text":
+	case "json":
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		errs = append(errs, fmt.Sprintf("not a valid log format: %q, expected text or json", format))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
 }
 
 func Trace() runtime.Frame {
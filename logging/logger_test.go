@@ -0,0 +1,103 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigure(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  string
+		format string
+		want   logrus.Level
+		json   bool
+		err    bool
+	}{
+		{name: "defaults", want: logrus.InfoLevel},
+		{name: "debug json", level: "debug", format: "json", want: logrus.DebugLevel, json: true},
+		{name: "format is case insensitive", level: "warn", format: "JSON", want: logrus.WarnLevel, json: true},
+		{name: "bad level", level: "loud", format: "text", want: logrus.InfoLevel, err: true},
+		{name: "bad format", format: "xml", want: logrus.InfoLevel, err: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetLevel(logrus.InfoLevel)
+			err := configure(logger, test.level, test.format)
+			assert.Equal(t, test.err, err != nil)
+			assert.Equal(t, test.want, logger.GetLevel())
+			_, isJSON := logger.Formatter.(*logrus.JSONFormatter)
+			assert.Equal(t, test.json, isJSON)
+		})
+	}
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	var out bytes.Buffer
+	Logger.SetOutput(&out)
+	Logger.SetFormatter(&logrus.JSONFormatter{})
+	t.Cleanup(func() {
+		Logger = makeLogger()
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/", func(c *gin.Context) {
+		c.Set(UsernameKey, "someone")
+		ctx, cancel := context.WithCancel(c)
+		defer cancel()
+		Logger.WithContext(ctx).Info("handling")
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		header   string
+		upstream bool
+	}{
+		{name: "generated", header: ""},
+		{name: "from upstream", header: "abc-123", upstream: true},
+		{name: "junk from upstream", header: "abc\n123"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(RequestIDHeader, test.header)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			requestID := w.Header().Get(RequestIDHeader)
+			assert.True(t, validRequestID.MatchString(requestID))
+			if test.upstream {
+				assert.Equal(t, test.header, requestID)
+			}
+
+			decoder := json.NewDecoder(&out)
+			lines := 0
+			for {
+				line := map[string]any{}
+				if err := decoder.Decode(&line); err == io.EOF {
+					break
+				} else if !assert.NoError(t, err) {
+					return
+				}
+				lines++
+				assert.Equal(t, requestID, line[RequestIDKey])
+				assert.Equal(t, "someone", line[UsernameKey])
+			}
+			assert.Equal(t, 2, lines)
+		})
+	}
+}
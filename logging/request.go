@@ -0,0 +1,81 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// RequestIDKey is where the request ID is stored on the gin context, and the field it's logged as
+	RequestIDKey = "requestId"
+	// UsernameKey is where the logged in user is stored on the gin context, and the field it's logged as
+	UsernameKey = "username"
+	// RequestIDHeader carries the request ID, it is reused if a proxy in front of us already set one
+	RequestIDHeader = "X-Request-ID"
+)
+
+// Request IDs from upstream are only trusted if they look like one, so they can't inject junk into the logs
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// contextHook adds the request ID and username to entries logged with WithContext during a request
+type contextHook struct{}
+
+func (contextHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (contextHook) Fire(entry *logrus.Entry) error {
+	if entry.Context == nil {
+		return nil
+	}
+	for _, key := range []string{RequestIDKey, UsernameKey} {
+		if _, ok := entry.Data[key]; ok {
+			continue
+		}
+		if value := entry.Context.Value(key); value != nil {
+			entry.Data[key] = value
+		}
+	}
+	return nil
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware gives every request an ID, returned in the X-Request-ID header, and logs each request once it's handled
+//
+// Anything logged with Logger.WithContext(c) while handling the request gets the same ID
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set(RequestIDKey, requestID)
+		c.Header(RequestIDHeader, requestID)
+
+		c.Next()
+
+		entry := Logger.WithContext(c).WithFields(logrus.Fields{
+			"method":   c.Request.Method,
+			"path":     c.Request.URL.Path,
+			"status":   c.Writer.Status(),
+			"latency":  time.Since(start).String(),
+			"clientIp": c.ClientIP(),
+		})
+		if len(c.Errors) > 0 {
+			entry.Error(c.Errors.String())
+			return
+		}
+		entry.Info("request")
+	}
+}
@@ -1,29 +1,35 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"html/template"
+	"math"
 	"net/http"
-	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 
 	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/config"
 	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/directory"
 	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/metrics"
 	"github.com/computersciencehouse/vote/sse"
+	"github.com/computersciencehouse/vote/webhook"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 	"mvdan.cc/xurls/v2"
 )
 
-var VOTE_TOKEN = os.Getenv("VOTE_TOKEN")
-var CONDITIONAL_GATEKEEP_URL = os.Getenv("VOTE_CONDITIONAL_URL")
-var VOTE_HOST = os.Getenv("VOTE_HOST")
+// The URL vote is served from, for links in Slack messages
+var voteHost string
 
-// Dev mode flags
-var DEV_DISABLE_ACTIVE_FILTERS bool = os.Getenv("DEV_DISABLE_ACTIVE_FILTERS") == "true"
-var DEV_FORCE_IS_EBOARD bool = os.Getenv("DEV_FORCE_IS_EBOARD") == "true"
-var DEV_FORCE_IS_EVALS bool = os.Getenv("DEV_FORCE_IS_EVALS") == "true"
+// How long requests in progress get to finish when shutting down
+const shutdownTimeout = 30 * time.Second
 
 func inc(x int) string {
 	return strconv.Itoa(x + 1)
@@ -36,70 +42,184 @@ func MakeLinks(s string) template.HTML {
 	return template.HTML(safe)
 }
 
-var oidcClient = OIDCClient{}
+var userDirectory directory.Directory
 var broker *sse.Broker
 
+// registerGauges Exposes values that are read when /metrics is scraped
+func registerGauges() {
+	metrics.Gauge("open_polls", "Polls that are currently open.", func() float64 {
+		count, err := database.CountOpenPolls(context.Background())
+		if err != nil {
+			logging.Logger.WithFields(logrus.Fields{"method": "registerGauges"}).Error(err)
+			return math.NaN()
+		}
+		return float64(count)
+	})
+	metrics.Gauge("sse_clients", "Clients connected to the live results stream.", func() float64 {
+		return float64(broker.Clients())
+	})
+}
+
 func main() {
 	godotenv.Load()
-	database.Client = database.Connect()
-	r := gin.Default()
+	cfg, err := config.Load()
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Fatal(err)
+	}
+	if err := logging.Configure(cfg.Log.Level, cfg.Log.Format); err != nil {
+		logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Warning(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		if cfg.IsProduction() {
+			logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Fatal(err)
+		}
+		logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Warning(err)
+	}
+	voteHost = cfg.Host
+	initSecurity(cfg.OIDC.JWTSecret, cfg.Host)
+
+	database.Client = database.Connect(cfg.Mongo.URI, cfg.Mongo.Database)
+	// gin's own output, like routes in debug mode and recovered panics, goes through our logger too
+	gin.DefaultWriter = logging.Logger.WriterLevel(logrus.DebugLevel)
+	gin.DefaultErrorWriter = logging.Logger.WriterLevel(logrus.ErrorLevel)
+	r := gin.New()
+	r.Use(logging.Middleware(), gin.Recovery(), metrics.Middleware(), SecurityHeaders())
 	r.StaticFS("/static", http.Dir("static"))
 	r.SetFuncMap(template.FuncMap{
 		"inc":       inc,
 		"MakeLinks": MakeLinks,
 	})
 	r.LoadHTMLGlob("templates/*")
-	broker = sse.NewBroker()
-
-	csh := cshAuth.CSHAuth{}
-	csh.Init(
-		os.Getenv("VOTE_OIDC_ID"),
-		os.Getenv("VOTE_OIDC_SECRET"),
-		os.Getenv("VOTE_JWT_SECRET"),
-		os.Getenv("VOTE_STATE"),
-		VOTE_HOST,
-		VOTE_HOST+"/auth/callback",
-		VOTE_HOST+"/auth/login",
-		[]string{"profile", "email", "groups"},
-	)
-	oidcClient.setupOidcClient(os.Getenv("VOTE_OIDC_ID"), os.Getenv("VOTE_OIDC_SECRET"))
-	InitConstitution()
-
-	if DEV_DISABLE_ACTIVE_FILTERS {
-		logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Warning("Dev disable active filters is set!")
+	broker = sse.NewBroker(sse.Config{})
+	StartPollWatcher()
+	registerGauges()
+
+	var static *directory.Static
+	if cfg.DirectoryFile != "" {
+		static, err = directory.LoadStatic(cfg.DirectoryFile)
+		if err != nil {
+			logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Fatal(err)
+		}
+		logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Warning("Using static directory " + cfg.DirectoryFile)
+		userDirectory = static
+	} else {
+		userDirectory = directory.NewCSH(
+			directory.KeycloakConfig{
+				Issuer:       cshAuth.ProviderURI,
+				ClientID:     cfg.OIDC.ClientID,
+				ClientSecret: cfg.OIDC.ClientSecret,
+			},
+			directory.ConditionalConfig{
+				GatekeepURL: cfg.Conditional.GatekeepURL,
+				Token:       cfg.Conditional.Token,
+			},
+		)
 	}
-	if DEV_FORCE_IS_EBOARD {
-		logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Warning("Dev force eboard is set!")
-	}
-	if DEV_FORCE_IS_EVALS {
-		logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Warning("Dev force evals is set!")
+	InitConstitution(cfg.Slack)
+
+	webhooks = webhook.NewDispatcher(webhookStore{}, webhook.Config{})
+	webhooks.Start()
+
+	var authenticator Authenticator
+	if cfg.DevLogin {
+		if static == nil {
+			logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Fatal("VOTE_DEV_LOGIN needs VOTE_DIRECTORY_FILE to pick users from")
+		}
+		logging.Logger.WithFields(logrus.Fields{"method": "main init"}).Warning("Dev login is enabled, anyone can log in as anyone!")
+		authenticator = NewDevAuth(static)
+	} else {
+		csh := cshAuth.CSHAuth{}
+		csh.Init(
+			cfg.OIDC.ClientID,
+			cfg.OIDC.ClientSecret,
+			cfg.OIDC.JWTSecret,
+			cfg.OIDC.State,
+			cfg.Host,
+			cfg.Host+"/auth/callback",
+			cfg.Host+"/auth/login",
+			[]string{"profile", "email", "groups"},
+		)
+		authenticator = NewSSOAuth(&csh, cfg.OIDC.JWTSecret)
 	}
 
-	r.GET("/auth/login", csh.AuthRequest)
-	r.GET("/auth/callback", csh.AuthCallback)
-	r.GET("/auth/logout", csh.AuthLogout)
+	authenticator.Routes(r)
+	auth := RequireAuth(authenticator)
+
+	r.GET("/", auth, GetHomepage)
+	r.GET("/closed", auth, GetClosedPolls)
 
-	r.GET("/", csh.AuthWrapper(GetHomepage))
-	r.GET("/closed", csh.AuthWrapper(GetClosedPolls))
+	r.GET("/create", auth, RequirePolicy(ActionCreate), GetCreatePage)
+	r.POST("/create", auth, RequirePolicy(ActionCreate), CreatePoll)
 
-	r.GET("/create", csh.AuthWrapper(GetCreatePage))
-	r.POST("/create", csh.AuthWrapper(CreatePoll))
+	r.GET("/poll/:id", auth, GetPollById)
+	r.POST("/poll/:id", auth, VoteInPoll)
 
-	r.GET("/poll/:id", csh.AuthWrapper(GetPollById))
-	r.POST("/poll/:id", csh.AuthWrapper(VoteInPoll))
+	r.GET("/results/:id", auth, GetPollResults)
+	r.GET("/results/:id/export.csv", auth, RequirePolicy(ActionViewResults), ExportResultsCSV)
+	r.GET("/results/:id/export.json", auth, RequirePolicy(ActionViewResults), ExportResultsJSON)
+	r.GET("/results/:id/ballots", auth, RequirePolicy(ActionExportBallots), ExportBallots)
 
-	r.GET("/results/:id", csh.AuthWrapper(GetPollResults))
+	r.POST("/poll/:id/hide", auth, RequirePolicy(ActionHide), HidePollResults)
+	r.POST("/poll/:id/close", auth, RequirePolicy(ActionClose), ClosePoll)
 
-	r.POST("/poll/:id/hide", csh.AuthWrapper(HidePollResults))
-	r.POST("/poll/:id/close", csh.AuthWrapper(ClosePoll))
+	r.POST("/poll/:id/eligibility/resnapshot", auth, RequirePolicy(ActionManageEligibility), ResnapshotEligibility)
+	r.POST("/poll/:id/eligibility/add", auth, RequirePolicy(ActionManageEligibility), AddEligibleUser)
+	r.POST("/poll/:id/appeal", auth, FileAppeal)
 
-	r.GET("/eboard", csh.AuthWrapper(HandleGetEboardVote))
-	r.POST("/eboard", csh.AuthWrapper(HandlePostEboardVote))
-	r.POST("/eboard/manage", csh.AuthWrapper(HandleManageEboardVote))
+	r.GET("/appeals", auth, RequirePolicy(ActionReviewAppeals), GetAppeals)
+	r.POST("/appeals/:id/approve", auth, RequirePolicy(ActionReviewAppeals), ApproveAppeal)
+	r.POST("/appeals/:id/deny", auth, RequirePolicy(ActionReviewAppeals), DenyAppeal)
 
-	r.GET("/stream/:topic", csh.AuthWrapper(broker.ServeHTTP))
+	r.GET("/eboard", auth, RequirePolicy(ActionManageEboard), HandleGetEboardVote)
+	r.POST("/eboard", auth, RequirePolicy(ActionManageEboard), HandlePostEboardVote)
+	r.POST("/eboard/manage", auth, RequirePolicy(ActionManageEboard), HandleManageEboardVote)
 
-	go broker.Listen()
+	r.GET("/tokens", auth, RequirePolicy(ActionManageTokens), GetTokens)
+	r.POST("/tokens", auth, RequirePolicy(ActionManageTokens), CreateToken)
+	r.POST("/tokens/:id/revoke", auth, RequirePolicy(ActionManageTokens), RevokeToken)
 
-	r.Run()
+	r.GET("/webhooks", auth, RequirePolicy(ActionManageWebhooks), GetWebhooks)
+	r.POST("/webhooks", auth, RequirePolicy(ActionManageWebhooks), CreateWebhook)
+	r.POST("/webhooks/:id/delete", auth, RequirePolicy(ActionManageWebhooks), DeleteWebhook)
+
+	r.GET("/stream/:topic", auth, GetStream)
+	r.GET("/metrics", metrics.Handler(cfg.MetricsToken))
+	r.GET("/healthz", GetHealth)
+	r.GET("/readyz", GetReadiness)
+
+	RegisterAPIv1(r, authenticator)
+
+	server := &http.Server{Addr: ":" + cfg.Port, Handler: r}
+	// streams never finish on their own, so they're ended as soon as we start shutting down
+	server.RegisterOnShutdown(broker.Shutdown)
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logging.Logger.WithFields(logrus.Fields{"method": "main"}).Fatal(err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+	shutdown(server)
+}
+
+// shutdown Stops taking requests and lets everything in progress finish before disconnecting from the database
+func shutdown(server *http.Server) {
+	logging.Logger.WithFields(logrus.Fields{"method": "shutdown"}).Info("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logging.Logger.WithFields(logrus.Fields{"method": "shutdown"}).Error(err)
+	}
+
+	// the evaluator can close polls, which sends webhooks, so it stops first
+	StopConstitution()
+	StopPollWatcher()
+	webhooks.Stop()
+	if closer, ok := userDirectory.(interface{ Close() }); ok {
+		closer.Close()
+	}
+	database.Disconnect()
+	logging.Logger.WithFields(logrus.Fields{"method": "shutdown"}).Info("shut down")
 }
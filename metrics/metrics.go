@@ -0,0 +1,131 @@
+// Package metrics defines the Prometheus metrics vote exposes on /metrics
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const namespace = "vote"
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by route and status.",
+	}, []string{"method", "route", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to handle HTTP requests, by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// BallotsCast counts ballots cast, by vote type
+	BallotsCast = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ballots_cast_total",
+		Help:      "Ballots cast, by vote type.",
+	}, []string{"vote_type"})
+
+	mongoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_command_duration_seconds",
+		Help:      "Time taken by MongoDB commands, by command and outcome.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 10},
+	}, []string{"command", "outcome"})
+
+	// EvaluatorRuns counts runs of the daily gatekeep poll evaluation
+	EvaluatorRuns = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evaluator_runs_total",
+		Help:      "Runs of the daily gatekeep poll evaluation.",
+	})
+	// EvaluatorReminders counts reminders sent to voters who haven't voted in a gatekeep poll
+	EvaluatorReminders = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evaluator_reminders_sent_total",
+		Help:      "Reminders sent to eligible voters who haven't voted in a gatekeep poll.",
+	})
+	// EvaluatorPollsClosed counts gatekeep polls closed by the evaluation
+	EvaluatorPollsClosed = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evaluator_polls_closed_total",
+		Help:      "Gatekeep polls closed by the daily evaluation.",
+	})
+	// EvaluatorErrors counts errors during the evaluation, by the step that failed
+	EvaluatorErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evaluator_errors_total",
+		Help:      "Errors during the daily gatekeep poll evaluation, by step.",
+	}, []string{"step"})
+
+	// DirectoryErrors counts failed calls to Keycloak and conditional
+	DirectoryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "directory_errors_total",
+		Help:      "Failed calls to the member directory, by service and operation.",
+	}, []string{"service", "operation"})
+)
+
+// Handler serves the metrics in the Prometheus text format to requests with token as their bearer token
+//
+// Without a token the metrics aren't served to anyone
+func Handler(token string) gin.HandlerFunc {
+	serve := gin.WrapH(promhttp.Handler())
+	return func(c *gin.Context) {
+		if token == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("Authorization")), []byte("Bearer "+token)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+		serve(c)
+	}
+}
+
+// Middleware records the count and latency of every request by route
+//
+// Requests that don't match a route are grouped together so scanners can't create unbounded label values
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpRequests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Gauge registers a gauge that is read from value whenever metrics are scraped
+func Gauge(name string, help string, value func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, value)
+}
+
+// MongoMonitor records how long every MongoDB command takes
+func MongoMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, succeeded *event.CommandSucceededEvent) {
+			mongoDuration.WithLabelValues(succeeded.CommandName, "success").Observe(succeeded.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, failed *event.CommandFailedEvent) {
+			mongoDuration.WithLabelValues(failed.CommandName, "failure").Observe(failed.Duration.Seconds())
+		},
+	}
+}
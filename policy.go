@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/gin-gonic/gin"
+)
+
+// Action is something a user can try to do, the policy decides whether they may
+type Action string
+
+const (
+	ActionCreate            Action = "create"
+	ActionCreateGatekeep    Action = "create-gatekeep"
+	ActionVote              Action = "vote"
+	ActionClose             Action = "close"
+	ActionHide              Action = "hide"
+	ActionViewResults       Action = "view-results"
+	ActionManageEboard      Action = "manage-eboard"
+	ActionManageEligibility Action = "manage-eligibility"
+	ActionReviewAppeals     Action = "review-appeals"
+	ActionManageTokens      Action = "manage-tokens"
+	ActionManageWebhooks    Action = "manage-webhooks"
+	ActionExportBallots     Action = "export-ballots"
+)
+
+// denial is a reason the policy refused to let a user do something, these are shown to the user
+type denial string
+
+func (err denial) Error() string {
+	return string(err)
+}
+
+var (
+	errNotActive        = denial("You need to be an active member to do that")
+	errNotEboard        = denial("You need to be E-Board to do that")
+	errNotEvals         = denial("You need to be Evals to do that")
+	errNotRTP           = denial("You need to be an RTP to do that")
+	errNotEligible      = denial("You are not eligible to vote in this poll")
+	errNotInAudience    = denial("This poll is only open to its audience")
+	errPollClosed       = denial("This poll is closed")
+	errNotOwner         = denial("Only the creator of this poll can do that")
+	errCannotClose      = denial("You cannot end this poll")
+	errGatekeepNoManual = denial("This poll cannot be closed manually")
+	errResultsHidden    = denial("Results of this poll are hidden until it closes")
+	errBallotsSealed    = denial("Ballots can only be exported once the poll closes")
+	errUnknownAction    = denial("Unknown action")
+)
+
+// A rule decides whether a user may take an action, returning why not if they can't
+//
+// poll is nil for actions that aren't about a specific poll
+type rule func(user cshAuth.CSHUserInfo, poll *database.Poll) error
+
+// policy maps every action to the rule that guards it
+var policy = map[Action]rule{
+	ActionCreate: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsActive(user) {
+			return errNotActive
+		}
+		return nil
+	},
+	ActionCreateGatekeep: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsActive(user) {
+			return errNotActive
+		}
+		if !IsEboard(user) {
+			return errNotEboard
+		}
+		return nil
+	},
+	// Whether the user has already voted is checked separately by canVote, as it needs the database
+	ActionVote: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsActive(user) {
+			return errNotActive
+		}
+		if !poll.Open {
+			return errPollClosed
+		}
+		if poll.Gatekeep && !slices.Contains(poll.AllowedUsers, user.Username) {
+			return errNotEligible
+		}
+		if len(poll.Audience) > 0 && !slices.Contains(poll.AllowedUsers, user.Username) {
+			return errNotInAudience
+		}
+		return nil
+	},
+	ActionClose: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if poll.Gatekeep {
+			return errGatekeepNoManual
+		}
+		if !ownsPoll(poll, user) && !IsActiveRTP(user) && !IsEboard(user) {
+			return errCannotClose
+		}
+		return nil
+	},
+	ActionHide: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !ownsPoll(poll, user) {
+			return errNotOwner
+		}
+		return nil
+	},
+	// Results are intentionally visible to everyone, a user may be unable to vote but still interested in the results
+	ActionViewResults: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if poll.Hidden && poll.Open {
+			return errResultsHidden
+		}
+		return nil
+	},
+	// Anonymized ballots let members recount a poll themselves, but not while it's still running
+	ActionExportBallots: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsActive(user) {
+			return errNotActive
+		}
+		if poll.Open {
+			return errBallotsSealed
+		}
+		return nil
+	},
+	ActionManageEboard: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsEboard(user) {
+			return errNotEboard
+		}
+		return nil
+	},
+	ActionManageEligibility: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsEvals(user) {
+			return errNotEvals
+		}
+		return nil
+	},
+	ActionReviewAppeals: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsEvals(user) {
+			return errNotEvals
+		}
+		return nil
+	},
+	ActionManageTokens: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsActiveRTP(user) {
+			return errNotRTP
+		}
+		return nil
+	},
+	ActionManageWebhooks: func(user cshAuth.CSHUserInfo, poll *database.Poll) error {
+		if !IsActiveRTP(user) {
+			return errNotRTP
+		}
+		return nil
+	},
+}
+
+// pollActions are the actions that are about a specific poll
+var pollActions = []Action{ActionVote, ActionClose, ActionHide, ActionViewResults, ActionManageEligibility, ActionExportBallots}
+
+// Authorize returns nil if the user may take an action, or the reason they may not
+func Authorize(user cshAuth.CSHUserInfo, action Action, poll *database.Poll) error {
+	check, ok := policy[action]
+	if !ok {
+		return errUnknownAction
+	}
+	return check(user, poll)
+}
+
+// Can reports whether the user may take an action
+func Can(user cshAuth.CSHUserInfo, action Action, poll *database.Poll) bool {
+	return Authorize(user, action, poll) == nil
+}
+
+// RequirePolicy is middleware that only lets a request through if the policy allows the action
+//
+// For actions about a specific poll, the poll is loaded from the id parameter and can be
+// retrieved by the handler with GetPollData
+func RequirePolicy(action Action) gin.HandlerFunc {
+	return requirePolicy(action, func(c *gin.Context, err error) {
+		var denied denial
+		if !errors.As(err, &denied) {
+			c.AbortWithStatusJSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		user := GetUserData(c)
+		render(c, http.StatusForbidden, "unauthorized.tmpl", gin.H{
+			"Reason":   err.Error(),
+			"Username": user.Username,
+			"FullName": user.FullName,
+			"EBoard":   IsEboard(user),
+			"Evals":    IsEvals(user),
+		})
+		c.Abort()
+	})
+}
+
+func requirePolicy(action Action, fail func(c *gin.Context, err error)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := GetUserData(c)
+
+		var poll *database.Poll
+		if slices.Contains(pollActions, action) {
+			var err error
+			poll, err = loadPoll(c, c.Param("id"))
+			if err != nil {
+				fail(c, err)
+				return
+			}
+			c.Set("poll", poll)
+		}
+
+		if err := Authorize(user, action, poll); err != nil {
+			fail(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetPollData Retreives the poll loaded by RequirePolicy
+func GetPollData(c *gin.Context) *database.Poll {
+	return c.MustGet("poll").(*database.Poll)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	inactive := cshAuth.CSHUserInfo{Username: "inactive", Groups: []string{"member"}}
+	active := cshAuth.CSHUserInfo{Username: "active", Groups: []string{"member", "active"}}
+	owner := cshAuth.CSHUserInfo{Username: "owner", Groups: []string{"member", "active"}}
+	eboard := cshAuth.CSHUserInfo{Username: "eboard", Groups: []string{"member", "active", "eboard"}}
+	evals := cshAuth.CSHUserInfo{Username: "evals", Groups: []string{"member", "active", "eboard", "eboard-evaluations"}}
+	rtp := cshAuth.CSHUserInfo{Username: "rtp", Groups: []string{"member", "active", "active-rtp"}}
+
+	open := &database.Poll{CreatedBy: "owner", Open: true}
+	closed := &database.Poll{CreatedBy: "owner", Open: false}
+	hidden := &database.Poll{CreatedBy: "owner", Open: true, Hidden: true}
+	hiddenClosed := &database.Poll{CreatedBy: "owner", Open: false, Hidden: true}
+	gatekeep := &database.Poll{CreatedBy: "owner", Open: true, Gatekeep: true, AllowedUsers: []string{"active", "eboard"}}
+	audience := &database.Poll{CreatedBy: "owner", Open: true, Audience: []string{"eboard"}, AllowedUsers: []string{"eboard", "evals"}}
+
+	tests := []struct {
+		name   string
+		user   cshAuth.CSHUserInfo
+		action Action
+		poll   *database.Poll
+		err    error
+	}{
+		{name: "inactive create", user: inactive, action: ActionCreate, err: errNotActive},
+		{name: "active create", user: active, action: ActionCreate},
+		{name: "eboard create", user: eboard, action: ActionCreate},
+
+		{name: "inactive create gatekeep", user: inactive, action: ActionCreateGatekeep, err: errNotActive},
+		{name: "active create gatekeep", user: active, action: ActionCreateGatekeep, err: errNotEboard},
+		{name: "rtp create gatekeep", user: rtp, action: ActionCreateGatekeep, err: errNotEboard},
+		{name: "eboard create gatekeep", user: eboard, action: ActionCreateGatekeep},
+
+		{name: "inactive vote", user: inactive, action: ActionVote, poll: open, err: errNotActive},
+		{name: "active vote", user: active, action: ActionVote, poll: open},
+		{name: "active vote closed", user: active, action: ActionVote, poll: closed, err: errPollClosed},
+		{name: "active vote gatekeep allowed", user: active, action: ActionVote, poll: gatekeep},
+		{name: "owner vote gatekeep not allowed", user: owner, action: ActionVote, poll: gatekeep, err: errNotEligible},
+		{name: "rtp vote gatekeep not allowed", user: rtp, action: ActionVote, poll: gatekeep, err: errNotEligible},
+		{name: "eboard vote audience member", user: eboard, action: ActionVote, poll: audience},
+		{name: "active vote audience non-member", user: active, action: ActionVote, poll: audience, err: errNotInAudience},
+		{name: "owner vote audience non-member", user: owner, action: ActionVote, poll: audience, err: errNotInAudience},
+		{name: "inactive vote audience", user: inactive, action: ActionVote, poll: audience, err: errNotActive},
+
+		{name: "inactive close", user: inactive, action: ActionClose, poll: open, err: errCannotClose},
+		{name: "active close", user: active, action: ActionClose, poll: open, err: errCannotClose},
+		{name: "owner close", user: owner, action: ActionClose, poll: open},
+		{name: "rtp close", user: rtp, action: ActionClose, poll: open},
+		{name: "eboard close", user: eboard, action: ActionClose, poll: open},
+		{name: "owner close gatekeep", user: owner, action: ActionClose, poll: gatekeep, err: errGatekeepNoManual},
+		{name: "eboard close gatekeep", user: eboard, action: ActionClose, poll: gatekeep, err: errGatekeepNoManual},
+
+		{name: "owner hide", user: owner, action: ActionHide, poll: open},
+		{name: "active hide", user: active, action: ActionHide, poll: open, err: errNotOwner},
+		{name: "rtp hide", user: rtp, action: ActionHide, poll: open, err: errNotOwner},
+		{name: "eboard hide", user: eboard, action: ActionHide, poll: open, err: errNotOwner},
+
+		{name: "inactive view results", user: inactive, action: ActionViewResults, poll: open},
+		{name: "active view hidden results", user: active, action: ActionViewResults, poll: hidden, err: errResultsHidden},
+		{name: "owner view hidden results", user: owner, action: ActionViewResults, poll: hidden, err: errResultsHidden},
+		{name: "active view hidden closed results", user: active, action: ActionViewResults, poll: hiddenClosed},
+
+		{name: "active export ballots", user: active, action: ActionExportBallots, poll: closed},
+		{name: "active export hidden closed ballots", user: active, action: ActionExportBallots, poll: hiddenClosed},
+		{name: "owner export open ballots", user: owner, action: ActionExportBallots, poll: open, err: errBallotsSealed},
+		{name: "inactive export ballots", user: inactive, action: ActionExportBallots, poll: closed, err: errNotActive},
+
+		{name: "active manage eboard", user: active, action: ActionManageEboard, err: errNotEboard},
+		{name: "rtp manage eboard", user: rtp, action: ActionManageEboard, err: errNotEboard},
+		{name: "eboard manage eboard", user: eboard, action: ActionManageEboard},
+
+		{name: "eboard manage eligibility", user: eboard, action: ActionManageEligibility, poll: gatekeep, err: errNotEvals},
+		{name: "evals manage eligibility", user: evals, action: ActionManageEligibility, poll: gatekeep},
+		{name: "eboard review appeals", user: eboard, action: ActionReviewAppeals, err: errNotEvals},
+		{name: "evals review appeals", user: evals, action: ActionReviewAppeals},
+
+		{name: "eboard manage tokens", user: eboard, action: ActionManageTokens, err: errNotRTP},
+		{name: "rtp manage tokens", user: rtp, action: ActionManageTokens},
+		{name: "eboard manage webhooks", user: eboard, action: ActionManageWebhooks, err: errNotRTP},
+		{name: "rtp manage webhooks", user: rtp, action: ActionManageWebhooks},
+
+		{name: "unknown action", user: evals, action: Action("delete"), err: errUnknownAction},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.err, Authorize(test.user, test.action, test.poll))
+			assert.Equal(t, test.err == nil, Can(test.user, test.action, test.poll))
+		})
+	}
+}
+
+func TestPolicyCoversActions(t *testing.T) {
+	for _, action := range pollActions {
+		_, ok := policy[action]
+		assert.True(t, ok, "poll action %s has no rule", action)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PollRequest is everything a user chooses when creating a poll
+type PollRequest struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Ranked      bool   `json:"ranked"`
+	// Leave empty for Pass, Fail and Abstain. Simple polls always get an Abstain option
+	Options       []string `json:"options"`
+	AllowWriteIns bool     `json:"allowWriteIns"`
+	Hidden        bool     `json:"hidden"`
+	Gatekeep      bool     `json:"gatekeep"`
+	// Percentage of eligible voters needed for quorum in gatekeep polls, eg 50
+	QuorumPercent float64  `json:"quorumPercent"`
+	WaivedUsers   []string `json:"waivedUsers"`
+	Audience      []string `json:"audience"`
+}
+
+// pollRequestFromForm Parses the create page's form
+func pollRequestFromForm(c *gin.Context) PollRequest {
+	quorum, _ := strconv.ParseFloat(c.PostForm("quorumType"), 64)
+	req := PollRequest{
+		Title:         c.PostForm("title"),
+		Description:   c.PostForm("description"),
+		Ranked:        c.PostForm("rankedChoice") == "true",
+		AllowWriteIns: c.PostForm("allowWriteIn") == "true",
+		Hidden:        c.PostForm("hidden") == "true",
+		Gatekeep:      c.PostForm("gatekeep") == "true",
+		QuorumPercent: quorum,
+		WaivedUsers:   strings.Split(c.PostForm("waivedUsers"), ","),
+		Audience:      strings.Split(c.PostForm("audience"), ","),
+	}
+	switch c.PostForm("options") {
+	case "pass-fail-conditional":
+		req.Options = []string{"Pass", "Fail/Conditional", "Abstain"}
+	case "fail-conditional":
+		req.Options = []string{"Fail", "Conditional", "Abstain"}
+	case "custom":
+		req.Options = strings.Split(c.PostForm("customOptions"), ",")
+	}
+	return req
+}
+
+// newPoll Validates a poll request and builds the poll, snapshotting who is eligible to vote in it
+func newPoll(ctx context.Context, user cshAuth.CSHUserInfo, req PollRequest) (*database.Poll, error) {
+	if err := Authorize(user, ActionCreate, nil); err != nil {
+		return nil, err
+	}
+
+	poll := &database.Poll{
+		Id:            "",
+		CreatedBy:     user.Username,
+		Title:         strings.TrimSpace(req.Title),
+		Description:   req.Description,
+		VoteType:      database.POLL_TYPE_SIMPLE,
+		OpenedTime:    time.Now(),
+		Open:          true,
+		QuorumType:    req.QuorumPercent / 100,
+		Gatekeep:      req.Gatekeep,
+		AllowWriteIns: req.AllowWriteIns,
+		Hidden:        req.Hidden,
+	}
+	if poll.Title == "" {
+		return nil, userError("You need to give the poll a title")
+	}
+	if req.Ranked {
+		poll.VoteType = database.POLL_TYPE_RANKED
+	}
+
+	poll.Options = []string{}
+	for _, opt := range req.Options {
+		opt = strings.TrimSpace(opt)
+		if opt != "" && !slices.Contains(poll.Options, opt) {
+			poll.Options = append(poll.Options, opt)
+		}
+	}
+	if len(poll.Options) == 0 {
+		poll.Options = []string{"Pass", "Fail", "Abstain"}
+	}
+	if !slices.Contains(poll.Options, "Abstain") && poll.VoteType == database.POLL_TYPE_SIMPLE {
+		poll.Options = append(poll.Options, "Abstain")
+	}
+
+	audience := slices.DeleteFunc(slices.Clone(req.Audience), func(group string) bool {
+		return strings.TrimSpace(group) == ""
+	})
+
+	if poll.Gatekeep {
+		if err := Authorize(user, ActionCreateGatekeep, nil); err != nil {
+			return nil, err
+		}
+		if len(audience) > 0 {
+			return nil, userError("Gatekeep polls are already limited to eligible voters and can't also have an audience")
+		}
+		if poll.QuorumType <= 0 || poll.QuorumType > 1 {
+			return nil, userError("Quorum must be between 1% and 100%")
+		}
+		voters, err := userDirectory.GetEligibleVoters(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unable to get eligible voters from conditional: %w", errDirectoryUnavailable, err)
+		}
+		waivers, err := waivedEligibility(ctx, req.WaivedUsers, user.Username)
+		if err != nil {
+			return nil, err
+		}
+		poll.Eligibility = database.DedupeEligibility(append(gatekeepEligibility(voters, user.Username), waivers...))
+		poll.AllowedUsers = database.EligibleUsernames(poll.Eligibility)
+	}
+
+	if len(audience) > 0 {
+		groups, entries, err := audienceEligibility(ctx, audience, user.Username)
+		if err != nil {
+			return nil, err
+		}
+		poll.Audience = groups
+		poll.Eligibility = entries
+		poll.AllowedUsers = database.EligibleUsernames(entries)
+	}
+
+	return poll, nil
+}
+
+// closePoll Stops a poll from accepting votes and records who closed it
+func closePoll(ctx context.Context, user cshAuth.CSHUserInfo, poll *database.Poll) error {
+	err := poll.Close(ctx)
+	if errors.Is(err, database.ErrPollClosed) {
+		return errPollClosed
+	}
+	if err != nil {
+		return err
+	}
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	action := database.Action{
+		Id:     "",
+		PollId: pId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: "Close/End Poll",
+	}
+	err = database.WriteAction(ctx, &action)
+	if err != nil {
+		return err
+	}
+	certifyResult(ctx, poll, user.Username)
+	emitPollClosed(ctx, poll)
+	notifyPollChange(ctx, database.PollChange{PollId: poll.Id, Kind: database.PollOpenChanged})
+	return nil
+}
+
+// certifyResult Freezes the official result of a poll that just closed
+//
+// The poll is already closed, so a failure is logged rather than returned and can be fixed with votectl certify
+func certifyResult(ctx context.Context, poll *database.Poll, certifiedBy string) {
+	if _, err := poll.Certify(ctx, certifiedBy); err != nil {
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "certifyResult", "poll": poll.Id}).Error(err)
+	}
+}
+
+// hidePoll Hides the results of a poll until it closes and records who hid them
+func hidePoll(ctx context.Context, user cshAuth.CSHUserInfo, poll *database.Poll) error {
+	err := poll.Hide(ctx)
+	if err != nil {
+		return err
+	}
+	pId, _ := primitive.ObjectIDFromHex(poll.Id)
+	action := database.Action{
+		Id:     "",
+		PollId: pId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: "Hide Results",
+	}
+	return database.WriteAction(ctx, &action)
+}
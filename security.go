@@ -0,0 +1,132 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// csrfCookie holds a random value per browser that CSRF tokens are derived from
+	csrfCookie = "vote_csrf"
+	// csrfFormField is where forms send the CSRF token
+	csrfFormField = "csrf_token"
+	// csrfHeader is where scripts using the API with a session cookie send the CSRF token
+	csrfHeader = "X-CSRF-Token"
+	// cspNonceKey is where the nonce for this request's inline scripts is stored on the gin context
+	cspNonceKey = "cspNonce"
+)
+
+var (
+	// csrfKey signs CSRF tokens, it is derived from the session secret so every instance agrees
+	csrfKey []byte
+	// secureCookies is set when vote is served over https
+	secureCookies bool
+)
+
+// initSecurity Sets up CSRF tokens, falling back to a random key if there's no session secret
+func initSecurity(sessionSecret string, host string) {
+	if sessionSecret == "" {
+		csrfKey = make([]byte, 32)
+		rand.Read(csrfKey)
+	} else {
+		sum := sha256.Sum256([]byte("vote csrf " + sessionSecret))
+		csrfKey = sum[:]
+	}
+	secureCookies = strings.HasPrefix(host, "https://")
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// contentSecurityPolicy Only allows scripts from us, the CSH asset server and jsdelivr, and inline scripts carrying nonce
+func contentSecurityPolicy(nonce string) string {
+	return strings.Join([]string{
+		"default-src 'self'",
+		"script-src 'self' 'nonce-" + nonce + "' https://cdn.jsdelivr.net https://assets.csh.rit.edu",
+		"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://assets.csh.rit.edu",
+		"font-src 'self' https://cdn.jsdelivr.net https://assets.csh.rit.edu",
+		// profile pictures can redirect to wherever members host them
+		"img-src 'self' data: https:",
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}, "; ")
+}
+
+// SecurityHeaders Sets the Content-Security-Policy and related headers on every response
+func SecurityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nonce := randomToken()
+		c.Set(cspNonceKey, nonce)
+		c.Header("Content-Security-Policy", contentSecurityPolicy(nonce))
+		c.Header("X-Content-Type-Options", "nosniff")
+		c.Header("X-Frame-Options", "DENY")
+		c.Header("Referrer-Policy", "strict-origin-when-cross-origin")
+		if secureCookies {
+			c.Header("Strict-Transport-Security", "max-age=31536000")
+		}
+		c.Next()
+	}
+}
+
+// csrfSignature Binds a browser's CSRF cookie to the user, so a cookie planted by another site can't be used against them
+func csrfSignature(cookie string, username string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(cookie))
+	mac.Write([]byte{0})
+	mac.Write([]byte(username))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// csrfToken Returns the CSRF token for forms on this page, setting the CSRF cookie if the browser doesn't have one
+func csrfToken(c *gin.Context) string {
+	cookie, err := c.Cookie(csrfCookie)
+	if err != nil || cookie == "" {
+		cookie = randomToken()
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(csrfCookie, cookie, 0, "/", "", secureCookies, true)
+	}
+	return csrfSignature(cookie, c.GetString(logging.UsernameKey))
+}
+
+// checkCSRF Reports whether a request is safe from CSRF, requests that change something need a valid token
+func checkCSRF(c *gin.Context) bool {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	cookie, err := c.Cookie(csrfCookie)
+	if err != nil || cookie == "" {
+		return false
+	}
+	token := c.GetHeader(csrfHeader)
+	if token == "" {
+		token = c.PostForm(csrfFormField)
+	}
+	expected := csrfSignature(cookie, c.GetString(logging.UsernameKey))
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+// csrfFailed Rejects a request without a valid CSRF token
+func csrfFailed(c *gin.Context) {
+	c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This form has expired, go back, refresh the page and try again"})
+}
+
+// render Renders a template with the CSRF token for its forms and the nonce for its inline scripts
+func render(c *gin.Context, status int, name string, data gin.H) {
+	data["CSRFToken"] = csrfToken(c)
+	data["Nonce"] = c.GetString(cspNonceKey)
+	c.HTML(status, name, data)
+}
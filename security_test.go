@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// addCSRF gives a request the CSRF cookie and header a browser logged in as username would send
+func addCSRF(req *http.Request, username string) {
+	req.AddCookie(&http.Cookie{Name: csrfCookie, Value: "browser"})
+	req.Header.Set(csrfHeader, csrfSignature("browser", username))
+}
+
+func TestCheckCSRF(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	initSecurity("secret", "https://vote.csh.rit.edu")
+	r := gin.New()
+	handler := func(c *gin.Context) {
+		c.Set(logging.UsernameKey, "member")
+		if !checkCSRF(c) {
+			csrfFailed(c)
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+	r.GET("/", handler)
+	r.POST("/", handler)
+
+	tests := []struct {
+		name   string
+		method string
+		cookie string
+		token  string
+		header bool
+		status int
+	}{
+		{name: "get", method: http.MethodGet, status: http.StatusOK},
+		{name: "post without token", method: http.MethodPost, cookie: "browser", status: http.StatusForbidden},
+		{name: "post without cookie", method: http.MethodPost, token: csrfSignature("browser", "member"), status: http.StatusForbidden},
+		{name: "post with form token", method: http.MethodPost, cookie: "browser", token: csrfSignature("browser", "member"), status: http.StatusOK},
+		{name: "post with header token", method: http.MethodPost, cookie: "browser", token: csrfSignature("browser", "member"), header: true, status: http.StatusOK},
+		{name: "token for another user", method: http.MethodPost, cookie: "browser", token: csrfSignature("browser", "eboard"), status: http.StatusForbidden},
+		{name: "planted cookie", method: http.MethodPost, cookie: "attacker", token: csrfSignature("browser", "member"), status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			form := url.Values{}
+			if test.token != "" && !test.header {
+				form.Set(csrfFormField, test.token)
+			}
+			req := httptest.NewRequest(test.method, "/", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if test.header {
+				req.Header.Set(csrfHeader, test.token)
+			}
+			if test.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: csrfCookie, Value: test.cookie})
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, test.status, w.Code)
+		})
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	initSecurity("secret", "https://vote.csh.rit.edu")
+	r := gin.New()
+	r.Use(SecurityHeaders())
+	var nonce string
+	r.GET("/", func(c *gin.Context) {
+		nonce = c.GetString(cspNonceKey)
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.NotEmpty(t, nonce)
+	assert.Contains(t, w.Header().Get("Content-Security-Policy"), "'nonce-"+nonce+"'")
+	assert.Equal(t, "DENY", w.Header().Get("X-Frame-Options"))
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.NotEmpty(t, w.Header().Get("Strict-Transport-Security"))
+}
@@ -24,107 +24,331 @@ CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 package sse
 
 import (
+	"cmp"
+	"fmt"
 	"io"
-	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/computersciencehouse/vote/logging"
+	ginsse "github.com/gin-contrib/sse"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
-const patience time.Duration = time.Second * 1
+// How long clients are told to wait before reconnecting when the server shuts down
+const shutdownRetry = 5 * time.Second
+
+// Events buffered for each client unless the config says otherwise
+const defaultBuffer = 16
+
+// How often idle streams get a comment unless the config says otherwise, well under the usual 60 second proxy timeout
+const defaultHeartbeat = 15 * time.Second
+
+// Recent events kept for each topic unless the config says otherwise
+const defaultReplay = 8
+
+// A topic's recent events are forgotten once nothing has been published to it for this long,
+// browsers reconnect within seconds so there's no point keeping them for closed polls
+const replayAge = 5 * time.Minute
+
+// Browsers send the id of the last event they got when reconnecting in this header
+const lastEventIDHeader = "Last-Event-ID"
+
+// SlowPolicy decides what happens when a client's buffer is full because it isn't reading fast enough
+type SlowPolicy int
+
+const (
+	// DropOldest discards the oldest buffered event to make room, fine when every event is a full snapshot
+	DropOldest SlowPolicy = iota
+	// Disconnect ends the client's stream, so it reconnects and starts again
+	Disconnect
+)
 
 type (
 	NotificationEvent struct {
+		// Id is set by Publish, it increases with every event so clients can say where they got up to
+		Id        uint64
 		EventName string
 		Payload   interface{}
 	}
 
-	NotifierChan chan NotificationEvent
+	Config struct {
+		// Buffer is how many events each client can fall behind by before Policy applies
+		Buffer int
+		Policy SlowPolicy
+		// Heartbeat is how often a comment is sent on streams, so proxies don't close them for being idle
+		Heartbeat time.Duration
+		// Replay is how many recent events are kept for each topic, to resend to clients that reconnect with Last-Event-ID
+		Replay int
+	}
 
-	Broker struct {
+	// history is a topic's recent events, oldest first
+	history struct {
+		events  []NotificationEvent
+		updated time.Time
+	}
+
+	// Subscription receives the events published to its topics
+	Subscription struct {
+		topics []string
+		events chan NotificationEvent
+		// closed when the broker disconnects a slow subscriber
+		gone     chan struct{}
+		goneOnce sync.Once
+	}
 
-		// Events are pushed to this channel by the main events-gathering routine
-		Notifier NotifierChan
+	// Broker delivers events to the clients subscribed to their topic
+	//
+	// Publishing never blocks, a client that can't keep up only affects itself
+	Broker struct {
+		config Config
 
-		// New client connections
-		newClients chan NotifierChan
+		lock sync.Mutex
+		// Subscriptions by topic
+		topics map[string]map[*Subscription]struct{}
+		// Recent events by topic
+		history map[string]*history
+		// Id of the last event published
+		lastID uint64
 
-		// Closed client connections
-		closingClients chan NotifierChan
+		// Number of subscribed clients, readable without the lock
+		clientCount atomic.Int64
 
-		// Client connections registry
-		clients map[NotifierChan]struct{}
+		// Closed to end every stream when the server shuts down
+		done     chan struct{}
+		doneOnce sync.Once
 	}
 )
 
-func NewBroker() (broker *Broker) {
-	// Instantiate a broker
+// Events returns the channel events for the subscription's topics arrive on
+func (sub *Subscription) Events() <-chan NotificationEvent {
+	return sub.events
+}
+
+// Gone is closed if the broker disconnects the subscriber for falling too far behind
+func (sub *Subscription) Gone() <-chan struct{} {
+	return sub.gone
+}
+
+func NewBroker(config Config) (broker *Broker) {
+	if config.Buffer <= 0 {
+		config.Buffer = defaultBuffer
+	}
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = defaultHeartbeat
+	}
+	if config.Replay <= 0 {
+		config.Replay = defaultReplay
+	}
+	// replayed events have to fit in a new subscriber's buffer
+	config.Replay = min(config.Replay, config.Buffer)
 	return &Broker{
-		Notifier:       make(NotifierChan, 1),
-		newClients:     make(chan NotifierChan),
-		closingClients: make(chan NotifierChan),
-		clients:        make(map[NotifierChan]struct{}),
+		config:  config,
+		topics:  make(map[string]map[*Subscription]struct{}),
+		history: make(map[string]*history),
+		// ids start from the current time so they keep increasing when vote restarts,
+		// otherwise browsers reconnecting to the new process would be ahead of it
+		lastID: uint64(time.Now().UnixMilli()),
+		done:   make(chan struct{}),
 	}
 }
 
-func (broker *Broker) ServeHTTP(c *gin.Context) {
-	eventName := c.Param("topic")
+// Subscribe registers a subscriber for one or more topics, it must be passed to Unsubscribe when it's done
+func (broker *Broker) Subscribe(topics ...string) *Subscription {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	return broker.subscribe(topics)
+}
+
+// SubscribeAfter registers a subscriber for one or more topics that starts with the recent events published after lastID,
+// so a client that reconnects doesn't miss anything
+func (broker *Broker) SubscribeAfter(lastID uint64, topics ...string) *Subscription {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	sub := broker.subscribe(topics)
+
+	var missed []NotificationEvent
+	for _, topic := range topics {
+		if history, ok := broker.history[topic]; ok {
+			for _, event := range history.events {
+				if event.Id > lastID {
+					missed = append(missed, event)
+				}
+			}
+		}
+	}
+	slices.SortFunc(missed, func(a, b NotificationEvent) int {
+		return cmp.Compare(a.Id, b.Id)
+	})
+	// the most recent events matter most if they don't all fit
+	if len(missed) > broker.config.Buffer {
+		missed = missed[len(missed)-broker.config.Buffer:]
+	}
+	for _, event := range missed {
+		sub.events <- event
+	}
+	return sub
+}
+
+func (broker *Broker) subscribe(topics []string) *Subscription {
+	sub := &Subscription{
+		topics: topics,
+		events: make(chan NotificationEvent, broker.config.Buffer),
+		gone:   make(chan struct{}),
+	}
+	for _, topic := range topics {
+		if broker.topics[topic] == nil {
+			broker.topics[topic] = make(map[*Subscription]struct{})
+		}
+		broker.topics[topic][sub] = struct{}{}
+	}
+	broker.clientCount.Add(1)
+	logging.Logger.WithFields(logrus.Fields{"module": "sse", "method": "Subscribe", "topics": topics, "clients": broker.clientCount.Load()}).Debug("Client added")
+	return sub
+}
 
-	// Each connection registers its own message channel with the Broker's connections registry
-	messageChan := make(NotifierChan)
+// Unsubscribe stops delivering events to a subscriber
+func (broker *Broker) Unsubscribe(sub *Subscription) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	subscribed := false
+	for _, topic := range sub.topics {
+		subs := broker.topics[topic]
+		if _, ok := subs[sub]; !ok {
+			continue
+		}
+		subscribed = true
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(broker.topics, topic)
+		}
+	}
+	if !subscribed {
+		return
+	}
+	broker.clientCount.Add(-1)
+	logging.Logger.WithFields(logrus.Fields{"module": "sse", "method": "Unsubscribe", "topics": sub.topics, "clients": broker.clientCount.Load()}).Debug("Removed client")
+}
 
-	// Signal the broker that we have a new connection
-	broker.newClients <- messageChan
+// Publish sends an event to everyone subscribed to topic, without waiting for any of them
+func (broker *Broker) Publish(topic string, event NotificationEvent) {
+	broker.lock.Lock()
+	defer broker.lock.Unlock()
+	broker.lastID++
+	event.Id = broker.lastID
+	broker.remember(topic, event)
 
-	// Remove this client from the map of connected clients
-	// when this handler exits.
-	defer func() {
-		broker.closingClients <- messageChan
-	}()
+	for sub := range broker.topics[topic] {
+		select {
+		case sub.events <- event:
+			continue
+		default:
+		}
 
-	c.Stream(func(w io.Writer) bool {
-		// Emit Server Sent Events compatible
-		event := <-messageChan
+		switch broker.config.Policy {
+		case Disconnect:
+			logging.Logger.WithFields(logrus.Fields{"module": "sse", "method": "Publish", "topic": topic}).Warning("Disconnecting slow client")
+			sub.goneOnce.Do(func() { close(sub.gone) })
+		default:
+			logging.Logger.WithFields(logrus.Fields{"module": "sse", "method": "Publish", "topic": topic}).Debug("Dropping event for slow client")
+			// only Publish sends, and it holds the lock, so there's room after taking one out
+			select {
+			case <-sub.events:
+			default:
+			}
+			sub.events <- event
+		}
+	}
+}
 
-		switch eventName {
-		case event.EventName:
-			c.SSEvent(event.EventName, event.Payload)
+// remember Keeps an event for replaying, and forgets topics that have gone quiet
+func (broker *Broker) remember(topic string, event NotificationEvent) {
+	now := time.Now()
+	for name, history := range broker.history {
+		if now.Sub(history.updated) > replayAge {
+			delete(broker.history, name)
 		}
+	}
 
-		// Flush the data immediately instead of buffering it for later.
-		c.Writer.Flush()
+	topicHistory, ok := broker.history[topic]
+	if !ok {
+		topicHistory = &history{}
+		broker.history[topic] = topicHistory
+	}
+	topicHistory.events = append(topicHistory.events, event)
+	if len(topicHistory.events) > broker.config.Replay {
+		topicHistory.events = topicHistory.events[len(topicHistory.events)-broker.config.Replay:]
+	}
+	topicHistory.updated = now
+}
 
-		return true
+// Shutdown ends every open stream, telling clients when to reconnect, so the server can stop without cutting them off mid event
+func (broker *Broker) Shutdown() {
+	broker.doneOnce.Do(func() {
+		close(broker.done)
 	})
 }
 
-// Listen for new notifications and redistribute them to clients
-func (broker *Broker) Listen() {
-	for {
+// ServeHTTP streams the events for the :topic route parameter
+func (broker *Broker) ServeHTTP(c *gin.Context) {
+	broker.Stream(c, c.Param("topic"))
+}
+
+// Stream streams the events for one or more topics until the client goes away, for handlers that decide what the client may subscribe to
+//
+// A client reconnecting with Last-Event-ID first gets any recent events it missed
+func (broker *Broker) Stream(c *gin.Context, topics ...string) {
+	var sub *Subscription
+	if lastID, err := strconv.ParseUint(c.GetHeader(lastEventIDHeader), 10, 64); err == nil {
+		sub = broker.SubscribeAfter(lastID, topics...)
+	} else {
+		sub = broker.Subscribe(topics...)
+	}
+	defer broker.Unsubscribe(sub)
+
+	// heartbeats can be the first thing written, so the headers can't be left to the first event
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(broker.config.Heartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
 		select {
-		case s := <-broker.newClients:
-
-			// A new client has connected.
-			// Register their message channel
-			broker.clients[s] = struct{}{}
-			log.Printf("Client added. %d registered clients", len(broker.clients))
-		case s := <-broker.closingClients:
-
-			// A client has dettached and we want to
-			// stop sending them messages.
-			delete(broker.clients, s)
-			log.Printf("Removed client. %d registered clients", len(broker.clients))
-		case event := <-broker.Notifier:
-
-			// We got a new event from the outside!
-			// Send event to all connected clients
-			for clientMessageChan := range broker.clients {
-				select {
-				case clientMessageChan <- event:
-				case <-time.After(patience):
-					log.Print("Skipping client.")
-				}
-			}
+		case event := <-sub.Events():
+			c.Render(-1, ginsse.Event{
+				Id:    strconv.FormatUint(event.Id, 10),
+				Event: event.EventName,
+				Data:  event.Payload,
+			})
+			// Flush the data immediately instead of buffering it for later.
+			c.Writer.Flush()
+			return true
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			c.Writer.Flush()
+			return true
+		case <-broker.done:
+			fmt.Fprintf(w, "retry: %d\n\n", shutdownRetry.Milliseconds())
+			c.Writer.Flush()
+			return false
+		case <-sub.Gone():
+			return false
+		case <-c.Request.Context().Done():
+			return false
 		}
-	}
+	})
+}
+
+// Clients returns how many clients are currently connected
+func (broker *Broker) Clients() int {
+	return int(broker.clientCount.Load())
 }
@@ -0,0 +1,195 @@
+package sse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownEndsStreams(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	broker := NewBroker(Config{})
+	r := gin.New()
+	r.GET("/stream/:topic", broker.ServeHTTP)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	// nothing is written until there's an event, so the response only arrives once the stream ends
+	body := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(srv.URL + "/stream/poll")
+		if err != nil {
+			body <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		body <- string(b)
+	}()
+	assert.Eventually(t, func() bool { return broker.Clients() == 1 }, time.Second, 10*time.Millisecond)
+
+	broker.Shutdown()
+	select {
+	case b := <-body:
+		assert.Equal(t, "retry: 5000\n\n", b)
+	case <-time.After(5 * time.Second):
+		t.Fatal("stream was not closed")
+	}
+	assert.Eventually(t, func() bool { return broker.Clients() == 0 }, time.Second, 10*time.Millisecond)
+}
+
+func TestPublishOnlyReachesTopic(t *testing.T) {
+	broker := NewBroker(Config{})
+	a := broker.Subscribe("a")
+	b := broker.Subscribe("b")
+	t.Cleanup(func() {
+		broker.Unsubscribe(a)
+		broker.Unsubscribe(b)
+	})
+
+	broker.Publish("a", NotificationEvent{EventName: "a", Payload: "1"})
+	broker.Publish("nobody", NotificationEvent{EventName: "nobody", Payload: "2"})
+
+	event := <-a.Events()
+	assert.Equal(t, "a", event.EventName)
+	assert.Equal(t, "1", event.Payload)
+	assert.Empty(t, a.Events())
+	assert.Empty(t, b.Events())
+}
+
+func TestUnsubscribe(t *testing.T) {
+	broker := NewBroker(Config{})
+	first := broker.Subscribe("poll")
+	second := broker.Subscribe("poll")
+	assert.Equal(t, 2, broker.Clients())
+
+	broker.Unsubscribe(first)
+	assert.Equal(t, 1, broker.Clients())
+	// unsubscribing twice doesn't count the client twice
+	broker.Unsubscribe(first)
+	assert.Equal(t, 1, broker.Clients())
+
+	broker.Publish("poll", NotificationEvent{EventName: "poll", Payload: "1"})
+	assert.Empty(t, first.Events())
+	assert.Len(t, second.Events(), 1)
+
+	broker.Unsubscribe(second)
+	assert.Equal(t, 0, broker.Clients())
+	assert.Empty(t, broker.topics)
+}
+
+func TestSlowConsumer(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    SlowPolicy
+		published int
+		events    []string
+		gone      bool
+	}{
+		{name: "keeps up", policy: DropOldest, published: 2, events: []string{"1", "2"}},
+		{name: "drop oldest", policy: DropOldest, published: 5, events: []string{"4", "5"}},
+		{name: "disconnect", policy: Disconnect, published: 5, events: []string{"1", "2"}, gone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker := NewBroker(Config{Buffer: 2, Policy: tt.policy})
+			sub := broker.Subscribe("poll")
+			t.Cleanup(func() { broker.Unsubscribe(sub) })
+
+			for i := 1; i <= tt.published; i++ {
+				broker.Publish("poll", NotificationEvent{EventName: "poll", Payload: fmt.Sprint(i)})
+			}
+
+			var got []string
+			for len(sub.Events()) > 0 {
+				got = append(got, (<-sub.Events()).Payload.(string))
+			}
+			assert.Equal(t, tt.events, got)
+
+			select {
+			case <-sub.Gone():
+				assert.True(t, tt.gone, "subscriber was disconnected")
+			default:
+				assert.False(t, tt.gone, "subscriber wasn't disconnected")
+			}
+		})
+	}
+}
+
+func TestSubscribeAfter(t *testing.T) {
+	broker := NewBroker(Config{Replay: 3})
+	for i := 1; i <= 5; i++ {
+		broker.Publish("poll", NotificationEvent{EventName: "poll", Payload: fmt.Sprint(i)})
+	}
+	broker.Publish("other", NotificationEvent{EventName: "other", Payload: "6"})
+	events := broker.history["poll"].events
+	assert.Len(t, events, 3)
+	for i := 1; i < len(events); i++ {
+		assert.Greater(t, events[i].Id, events[i-1].Id)
+	}
+
+	tests := []struct {
+		name   string
+		lastID uint64
+		events []string
+	}{
+		{name: "missed some", lastID: events[0].Id, events: []string{"4", "5"}},
+		{name: "up to date", lastID: events[2].Id, events: nil},
+		{name: "missed more than are kept", lastID: 0, events: []string{"3", "4", "5"}},
+		{name: "ahead of us", lastID: events[2].Id + 100, events: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := broker.SubscribeAfter(tt.lastID, "poll")
+			t.Cleanup(func() { broker.Unsubscribe(sub) })
+
+			var got []string
+			for len(sub.Events()) > 0 {
+				got = append(got, (<-sub.Events()).Payload.(string))
+			}
+			assert.Equal(t, tt.events, got)
+		})
+	}
+}
+
+func TestStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	broker := NewBroker(Config{Heartbeat: 50 * time.Millisecond})
+	r := gin.New()
+	r.GET("/stream/:topic", broker.ServeHTTP)
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+
+	broker.Publish("poll", NotificationEvent{EventName: "poll", Payload: "1"})
+	missed := broker.history["poll"].events[0].Id
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/stream/poll", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", fmt.Sprint(missed-1))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := bufio.NewScanner(resp.Body)
+	next := func() string {
+		lines.Scan()
+		return lines.Text()
+	}
+	assert.Equal(t, fmt.Sprintf("id:%d", missed), next())
+	assert.Equal(t, "event:poll", next())
+	assert.Equal(t, "data:1", next())
+	assert.Equal(t, "", next())
+	assert.Equal(t, ": heartbeat", next())
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Event names sent on streams
+const (
+	eventResults     = "results"
+	eventTurnout     = "turnout"
+	eventPollCreated = "poll-created"
+	eventPollClosed  = "poll-closed"
+	eventVoted       = "voted"
+)
+
+// homeTopic is where changes to the list of open polls are published, and the topic the homepage asks to stream
+const homeTopic = "home"
+
+// resultsTopic is where the full results of a poll are published, only while anyone may see them
+func resultsTopic(pollId string) string {
+	return "results/" + pollId
+}
+
+// turnoutTopic is where the number of votes in a poll is published, for users who can't see its results
+func turnoutTopic(pollId string) string {
+	return "turnout/" + pollId
+}
+
+// userTopic is where a user is told about their own votes, so every page they have open can show them
+func userTopic(username string) string {
+	return "user/" + username
+}
+
+// How long to wait before reopening the change stream after it fails
+const watchRetry = 30 * time.Second
+
+var (
+	// Set while a change stream is publishing every instance's changes, otherwise each instance only publishes its own
+	watchingPolls atomic.Bool
+	// Cancels the change stream
+	stopWatching context.CancelFunc = func() {}
+	// Closed once the change stream has stopped
+	watcherStopped = make(chan struct{})
+)
+
+// turnout is the payload of turnout events
+type turnout struct {
+	NumVotes int `json:"numVotes"`
+}
+
+// homepagePoll is the payload of homepage events about open polls
+type homepagePoll struct {
+	Id        string `json:"id"`
+	Title     string `json:"title,omitempty"`
+	CreatedBy string `json:"createdBy,omitempty"`
+	NumVotes  int    `json:"numVotes"`
+}
+
+// pollTopic Picks the topic a user watching a poll is sent, following the same rules as the results page
+func pollTopic(user cshAuth.CSHUserInfo, poll *database.Poll) string {
+	if Can(user, ActionViewResults, poll) {
+		return resultsTopic(poll.Id)
+	}
+	return turnoutTopic(poll.Id)
+}
+
+// GetStream Streams live updates of a poll, users who can't see its results only get the turnout
+//
+// The homepage streams the home topic instead, for polls opening, closing and being voted in
+func GetStream(c *gin.Context) {
+	user := GetUserData(c)
+	if c.Param("topic") == homeTopic {
+		broker.Stream(c, homeTopic, userTopic(user.Username))
+		return
+	}
+	poll, err := loadPoll(c, c.Param("topic"))
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	broker.Stream(c, pollTopic(user, poll))
+}
+
+// StartPollWatcher Publishes changes made by every instance while Mongo supports change streams, so viewers see
+// votes cast on other instances too
+//
+// When the stream fails it is reopened from where it got up to, so changes made while reconnecting are still
+// published, and this instance publishes its own changes in the meantime. If Mongo no longer has those changes,
+// the stream starts again from now and changes other instances made while it was down are missed.
+//
+// Without change streams, like against the standalone development server, each instance publishes its own changes,
+// which is all there is with a single instance
+func StartPollWatcher() {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopWatching = cancel
+	go func() {
+		defer close(watcherStopped)
+		var resumeToken bson.Raw
+		for {
+			err := followPolls(ctx, &resumeToken)
+			watchingPolls.Store(false)
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, database.ErrChangeStreamsUnsupported) {
+				logging.Logger.WithFields(logrus.Fields{"method": "StartPollWatcher"}).Info("Mongo doesn't support change streams, only this instance's changes will be published")
+				return
+			}
+			if errors.Is(err, database.ErrChangeStreamHistoryLost) {
+				resumeToken = nil
+			}
+			logging.Logger.WithFields(logrus.Fields{"method": "StartPollWatcher", "retry": watchRetry.String()}).Warning(err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(watchRetry):
+			}
+		}
+	}()
+}
+
+// StopPollWatcher Stops publishing changes from the change stream
+func StopPollWatcher() {
+	stopWatching()
+	<-watcherStopped
+}
+
+// followPolls Publishes every change from the change stream until it fails, keeping resumeToken up to date
+// with the last change published
+func followPolls(ctx context.Context, resumeToken *bson.Raw) error {
+	watcher, err := database.WatchPolls(ctx, *resumeToken)
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+	watchingPolls.Store(true)
+	logging.Logger.WithFields(logrus.Fields{"method": "followPolls"}).Info("publishing changes from every instance")
+
+	for {
+		change, err := watcher.Next(ctx)
+		if err != nil {
+			return err
+		}
+		publishPollChange(ctx, change)
+		*resumeToken = watcher.ResumeToken()
+	}
+}
+
+// notifyPollChange Publishes a change made by this instance, unless the change stream is going to
+func notifyPollChange(ctx context.Context, change database.PollChange) {
+	if watchingPolls.Load() {
+		return
+	}
+	publishPollChange(ctx, change)
+}
+
+// publishPollChange Publishes the latest state of a poll that changed
+func publishPollChange(ctx context.Context, change database.PollChange) {
+	// ballots are anonymous, so who voted comes separately, and only goes to them
+	if change.Kind == database.VoterRecorded {
+		publishJSON(ctx, userTopic(change.Voter), eventVoted, gin.H{"id": change.PollId})
+		return
+	}
+
+	poll, err := database.GetPoll(ctx, change.PollId)
+	if err != nil {
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "publishPollChange", "poll": change.PollId}).Error(err)
+		return
+	}
+	// a poll that was just created doesn't have any votes yet
+	if change.Kind == database.PollCreated {
+		publishPollListing(ctx, poll, 0)
+		return
+	}
+	results, err := poll.GetResult(ctx)
+	if err != nil {
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "publishPollChange", "poll": change.PollId}).Error(err)
+		return
+	}
+	apiResults := toAPIResults(poll, results)
+	if change.Kind == database.PollOpenChanged {
+		publishPollListing(ctx, poll, apiResults.NumVotes)
+	}
+	publishPollEvents(ctx, poll, apiResults)
+}
+
+// publishPollListing Adds a poll that opened to the homepage, or removes one that closed
+func publishPollListing(ctx context.Context, poll *database.Poll, numVotes int) {
+	if !poll.Open {
+		publishJSON(ctx, homeTopic, eventPollClosed, gin.H{"id": poll.Id})
+		return
+	}
+	publishJSON(ctx, homeTopic, eventPollCreated, homepagePoll{
+		Id:        poll.Id,
+		Title:     poll.Title,
+		CreatedBy: poll.CreatedBy,
+		NumVotes:  numVotes,
+	})
+}
+
+// publishPollEvents Publishes the turnout of a poll, and its results if they aren't hidden
+func publishPollEvents(ctx context.Context, poll *database.Poll, results APIResults) {
+	publishJSON(ctx, turnoutTopic(poll.Id), eventTurnout, turnout{NumVotes: results.NumVotes})
+	if poll.Open {
+		publishJSON(ctx, homeTopic, eventTurnout, homepagePoll{Id: poll.Id, NumVotes: results.NumVotes})
+	}
+
+	// results are broadcast, so they're only sent if they'd be shown to anyone at all
+	if Can(cshAuth.CSHUserInfo{}, ActionViewResults, poll) {
+		publishJSON(ctx, resultsTopic(poll.Id), eventResults, results.Rounds)
+	}
+}
+
+// publishJSON Publishes an event with a JSON payload
+func publishJSON(ctx context.Context, topic string, event string, payload any) {
+	bytes, err := json.Marshal(payload)
+	if err != nil {
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "publishJSON", "topic": topic}).Error(err)
+		return
+	}
+	broker.Publish(topic, sse.NotificationEvent{EventName: event, Payload: string(bytes)})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/sse"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPollEvents(t *testing.T) {
+	owner := cshAuth.CSHUserInfo{Username: "owner", Groups: []string{"member", "active"}}
+	results := APIResults{NumVotes: 3, Rounds: []map[string]int{{"Yes": 2, "No": 1}}}
+
+	tests := []struct {
+		name    string
+		poll    *database.Poll
+		topic   string
+		results string
+		home    string
+	}{
+		{
+			name:    "open",
+			poll:    &database.Poll{Id: "poll", CreatedBy: "owner", Open: true},
+			topic:   "results/poll",
+			results: `[{"No":1,"Yes":2}]`,
+			home:    `{"id":"poll","numVotes":3}`,
+		},
+		{
+			name:    "closed",
+			poll:    &database.Poll{Id: "poll", CreatedBy: "owner"},
+			topic:   "results/poll",
+			results: `[{"No":1,"Yes":2}]`,
+		},
+		{
+			name:  "hidden",
+			poll:  &database.Poll{Id: "poll", CreatedBy: "owner", Open: true, Hidden: true},
+			topic: "turnout/poll",
+			home:  `{"id":"poll","numVotes":3}`,
+		},
+		{
+			name:    "hidden closed",
+			poll:    &database.Poll{Id: "poll", CreatedBy: "owner", Hidden: true},
+			topic:   "results/poll",
+			results: `[{"No":1,"Yes":2}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// even the owner of a hidden poll can't watch its results
+			assert.Equal(t, tt.topic, pollTopic(owner, tt.poll))
+
+			broker = sse.NewBroker(sse.Config{})
+			resultsSub := broker.Subscribe(resultsTopic(tt.poll.Id))
+			turnoutSub := broker.Subscribe(turnoutTopic(tt.poll.Id))
+			homeSub := broker.Subscribe(homeTopic)
+			publishPollEvents(context.Background(), tt.poll, results)
+
+			event := <-turnoutSub.Events()
+			assert.Equal(t, eventTurnout, event.EventName)
+			assert.Equal(t, `{"numVotes":3}`, event.Payload)
+			// closed polls aren't on the homepage
+			if tt.home == "" {
+				assert.Empty(t, homeSub.Events())
+			} else {
+				event = <-homeSub.Events()
+				assert.Equal(t, eventTurnout, event.EventName)
+				assert.Equal(t, tt.home, event.Payload)
+			}
+			if tt.results == "" {
+				assert.Empty(t, resultsSub.Events())
+				return
+			}
+			event = <-resultsSub.Events()
+			assert.Equal(t, eventResults, event.EventName)
+			assert.Equal(t, tt.results, event.Payload)
+		})
+	}
+}
+
+func TestHomepageEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		publish func(ctx context.Context)
+		event   string
+		payload string
+	}{
+		{
+			name: "poll created",
+			publish: func(ctx context.Context) {
+				publishPollListing(ctx, &database.Poll{Id: "poll", Title: "<b>Test</b>", CreatedBy: "owner", Open: true}, 0)
+			},
+			event:   eventPollCreated,
+			payload: `{"id":"poll","title":"\u003cb\u003eTest\u003c/b\u003e","createdBy":"owner","numVotes":0}`,
+		},
+		{
+			name: "poll reopened",
+			publish: func(ctx context.Context) {
+				publishPollListing(ctx, &database.Poll{Id: "poll", Title: "Test", CreatedBy: "owner", Open: true}, 2)
+			},
+			event:   eventPollCreated,
+			payload: `{"id":"poll","title":"Test","createdBy":"owner","numVotes":2}`,
+		},
+		{
+			name: "poll closed",
+			publish: func(ctx context.Context) {
+				publishPollListing(ctx, &database.Poll{Id: "poll", Title: "Test", CreatedBy: "owner"}, 2)
+			},
+			event:   eventPollClosed,
+			payload: `{"id":"poll"}`,
+		},
+		{
+			name: "voted",
+			publish: func(ctx context.Context) {
+				publishPollChange(ctx, database.PollChange{PollId: "poll", Kind: database.VoterRecorded, Voter: "member"})
+			},
+			event:   eventVoted,
+			payload: `{"id":"poll"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			broker = sse.NewBroker(sse.Config{})
+			sub := broker.Subscribe(homeTopic, userTopic("member"))
+			other := broker.Subscribe(userTopic("other"))
+			tt.publish(context.Background())
+
+			event := <-sub.Events()
+			assert.Equal(t, tt.event, event.EventName)
+			assert.Equal(t, tt.payload, event.Payload)
+			assert.Empty(t, sub.Events())
+			assert.Empty(t, other.Events())
+		})
+	}
+}
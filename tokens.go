@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/directory"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Every API token starts with this, so they're easy to spot if one leaks
+const tokenPrefix = "vote_"
+
+// The key the API token used for a request is stored under in the gin context
+const tokenKey = "apiToken"
+
+var errInvalidToken = errors.New("Invalid API token")
+
+// generateToken Creates a new random API token, returning it along with the hash to store
+func generateToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// bearerToken Returns the API token a request was made with, if any
+func bearerToken(c *gin.Context) (string, bool) {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")), true
+}
+
+// authenticateToken Identifies a request made with an API token, storing the token and who it acts as
+//
+// Every use of a token is written to the action log
+func authenticateToken(c *gin.Context, secret string) error {
+	if !strings.HasPrefix(secret, tokenPrefix) {
+		return errInvalidToken
+	}
+	token, err := database.GetTokenByHash(c, hashToken(secret))
+	if errors.Is(err, database.ErrTokenNotFound) {
+		return errInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := tokenUser(c, token)
+	if err != nil {
+		return err
+	}
+	c.Set(cshAuth.AuthKey, cshAuth.CSHClaims{UserInfo: user})
+	c.Set(tokenKey, token)
+
+	if err := token.MarkUsed(c); err != nil {
+		logging.Logger.WithContext(c).WithFields(logrus.Fields{"method": "authenticateToken"}).Error(err)
+	}
+	pId, _ := primitive.ObjectIDFromHex(c.Param("id"))
+	action := database.Action{
+		Id:     "",
+		PollId: pId,
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: fmt.Sprintf("API Token %s: %s %s", token.Name, c.Request.Method, c.FullPath()),
+	}
+	return database.WriteAction(c, &action)
+}
+
+// tokenUser Builds the user a token acts as
+//
+// Tokens without an acting user get a service identity that isn't in any groups, so the policy only lets them read
+func tokenUser(ctx context.Context, token *database.APIToken) (cshAuth.CSHUserInfo, error) {
+	if token.ActingUser == "" {
+		return cshAuth.CSHUserInfo{
+			Username: "token:" + token.Name,
+			FullName: token.Name,
+		}, nil
+	}
+	user, err := userDirectory.GetUser(ctx, token.ActingUser)
+	if err != nil {
+		return cshAuth.CSHUserInfo{}, fmt.Errorf("%w: unable to look up %s: %w", errDirectoryUnavailable, token.ActingUser, err)
+	}
+	groups, err := userDirectory.GetUserGroups(ctx, token.ActingUser)
+	if err != nil {
+		return cshAuth.CSHUserInfo{}, fmt.Errorf("%w: unable to look up the groups of %s: %w", errDirectoryUnavailable, token.ActingUser, err)
+	}
+	return cshAuth.CSHUserInfo{
+		Username: user.Username,
+		FullName: user.FullName,
+		Groups:   groups,
+	}, nil
+}
+
+// RequireScope is middleware that only lets API token requests through if the token has a scope
+//
+// Requests from a logged in user aren't limited by scopes
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, ok := c.Get(tokenKey)
+		if ok && !slices.Contains(value.(*database.APIToken).Scopes, scope) {
+			apiAbort(c, http.StatusForbidden, "This token doesn't have the "+scope+" scope")
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetTokens Displays the API tokens for RTPs to manage
+func GetTokens(c *gin.Context) {
+	renderTokens(c, http.StatusOK, "", "")
+}
+
+// CreateToken Mints a new API token, showing it to the RTP this one time
+func CreateToken(c *gin.Context) {
+	user := GetUserData(c)
+
+	name := strings.TrimSpace(c.PostForm("name"))
+	if name == "" {
+		renderTokens(c, http.StatusBadRequest, "", "You need to give the token a name")
+		return
+	}
+	scopes := c.PostFormArray("scopes")
+	if len(scopes) == 0 {
+		renderTokens(c, http.StatusBadRequest, "", "You need to give the token at least one scope")
+		return
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(database.Scopes, scope) {
+			renderTokens(c, http.StatusBadRequest, "", "Unknown scope: "+scope)
+			return
+		}
+	}
+	actingUser := strings.TrimSpace(c.PostForm("actingUser"))
+	if actingUser != "" {
+		_, err := userDirectory.GetUser(c, actingUser)
+		if errors.Is(err, directory.ErrUserNotFound) {
+			renderTokens(c, http.StatusBadRequest, "", "Unknown user: "+actingUser)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	// Otherwise an RTP could cast ballots as anyone
+	if actingUser != "" && actingUser != user.Username && slices.Contains(scopes, database.SCOPE_POLLS_VOTE) {
+		renderTokens(c, http.StatusBadRequest, "", "Only tokens acting as yourself can have the "+database.SCOPE_POLLS_VOTE+" scope")
+		return
+	}
+
+	secret, hash, err := generateToken()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	token := database.APIToken{
+		Id:         "",
+		Name:       name,
+		Hash:       hash,
+		Scopes:     scopes,
+		ActingUser: actingUser,
+		CreatedBy:  user.Username,
+		CreatedAt:  time.Now(),
+	}
+	_, err = database.CreateToken(c, &token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	action := database.Action{
+		Id:     "",
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: fmt.Sprintf("Create API Token %s (scopes: %s; acting user: %s)", name, strings.Join(scopes, ", "), actingUser),
+	}
+	err = database.WriteAction(c, &action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	renderTokens(c, http.StatusCreated, secret, "")
+}
+
+// RevokeToken Stops an API token from being used
+func RevokeToken(c *gin.Context) {
+	user := GetUserData(c)
+
+	token, err := database.RevokeToken(c, c.Param("id"), user.Username)
+	if errors.Is(err, database.ErrTokenNotFound) {
+		c.Redirect(http.StatusFound, "/tokens")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	action := database.Action{
+		Id:     "",
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: "Revoke API Token " + token.Name,
+	}
+	err = database.WriteAction(c, &action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/tokens")
+}
+
+func renderTokens(c *gin.Context, status int, newToken string, formError string) {
+	user := GetUserData(c)
+
+	tokens, err := database.GetTokens(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	render(c, status, "tokens.tmpl", gin.H{
+		"Tokens":    tokens,
+		"Scopes":    database.Scopes,
+		"NewToken":  newToken,
+		"FormError": formError,
+		"Username":  user.Username,
+		"FullName":  user.FullName,
+		"EBoard":    IsEboard(user),
+		"Evals":     IsEvals(user),
+	})
+}
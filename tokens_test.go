@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/directory"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateToken(t *testing.T) {
+	token, hash, err := generateToken()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(token, tokenPrefix))
+	assert.Equal(t, hashToken(token), hash)
+	assert.NotContains(t, hash, token)
+
+	other, otherHash, err := generateToken()
+	assert.NoError(t, err)
+	assert.NotEqual(t, token, other)
+	assert.NotEqual(t, hash, otherHash)
+}
+
+func TestTokenUser(t *testing.T) {
+	static, err := directory.LoadStatic("dev/directory.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userDirectory = static
+
+	user, err := tokenUser(context.Background(), &database.APIToken{Name: "minutes-bot", ActingUser: "eboard"})
+	assert.NoError(t, err)
+	assert.Equal(t, "eboard", user.Username)
+	assert.True(t, IsEboard(user))
+
+	user, err = tokenUser(context.Background(), &database.APIToken{Name: "minutes-bot"})
+	assert.NoError(t, err)
+	assert.Equal(t, "token:minutes-bot", user.Username)
+	assert.False(t, IsActive(user))
+
+	_, err = tokenUser(context.Background(), &database.APIToken{Name: "minutes-bot", ActingUser: "nobody"})
+	assert.ErrorIs(t, err, directory.ErrUserNotFound)
+}
+
+func TestRequireScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name   string
+		token  *database.APIToken
+		status int
+	}{
+		{name: "logged in user", status: http.StatusOK},
+		{name: "token with scope", token: &database.APIToken{Scopes: []string{database.SCOPE_POLLS_READ}}, status: http.StatusOK},
+		{name: "token without scope", token: &database.APIToken{Scopes: []string{database.SCOPE_RESULTS_READ}}, status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := gin.New()
+			r.GET("/", func(c *gin.Context) {
+				if test.token != nil {
+					c.Set(tokenKey, test.token)
+				}
+			}, RequireScope(database.SCOPE_POLLS_READ), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, test.status, w.Code)
+		})
+	}
+}
+
+func TestImpersonatingTokenCantVote(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name  string
+		token *database.APIToken
+		error string
+	}{
+		{
+			name:  "acting as another user",
+			token: &database.APIToken{Scopes: []string{database.SCOPE_POLLS_VOTE}, ActingUser: "member", CreatedBy: "rtp"},
+			error: `{"error": {"code": "forbidden", "message": "Tokens acting as another user can't vote"}}`,
+		},
+		{
+			name:  "without the vote scope",
+			token: &database.APIToken{Scopes: []string{database.SCOPE_POLLS_READ}, ActingUser: "rtp", CreatedBy: "rtp"},
+			error: `{"error": {"code": "forbidden", "message": "This token doesn't have the polls:vote scope"}}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := gin.New()
+			r.POST("/api/v1/polls/:id/ballots", func(c *gin.Context) {
+				c.Set(cshAuth.AuthKey, cshAuth.CSHClaims{UserInfo: cshAuth.CSHUserInfo{Username: test.token.ActingUser, Groups: []string{"member", "active"}}})
+				c.Set(tokenKey, test.token)
+			}, RequireScope(database.SCOPE_POLLS_VOTE), APICastBallot)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/polls/poll/ballots", strings.NewReader(`{"option": "Yes"}`)))
+			assert.Equal(t, http.StatusForbidden, w.Code)
+			assert.JSONEq(t, test.error, w.Body.String())
+		})
+	}
+}
+
+func TestTokenImpersonates(t *testing.T) {
+	tests := []struct {
+		name         string
+		token        database.APIToken
+		impersonates bool
+	}{
+		{name: "service token", token: database.APIToken{CreatedBy: "rtp"}},
+		{name: "acting as minter", token: database.APIToken{ActingUser: "rtp", CreatedBy: "rtp"}},
+		{name: "acting as someone else", token: database.APIToken{ActingUser: "member", CreatedBy: "rtp"}, impersonates: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.impersonates, test.token.Impersonates())
+		})
+	}
+}
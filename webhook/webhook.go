@@ -0,0 +1,300 @@
+// Package webhook delivers signed notifications about polls to other services
+//
+// Events are queued without blocking the caller and delivered by a pool of workers, which record every attempt.
+// Each webhook is delivered to separately, and failed deliveries are retried with exponential backoff
+// on a timer, so a webhook that is down doesn't hold up the workers or other webhooks
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/sirupsen/logrus"
+)
+
+// Events a webhook can subscribe to
+const (
+	EventPollOpened   = "poll.opened"
+	EventPollClosed   = "poll.closed"
+	EventPollQuorum   = "poll.quorum"
+	EventPollResults  = "poll.results"
+	SignatureHeader   = "X-Vote-Signature"
+	EventHeader       = "X-Vote-Event"
+	DeliveryHeader    = "X-Vote-Delivery"
+	signaturePrefix   = "sha256="
+	defaultWorkers    = 4
+	defaultQueueSize  = 256
+	defaultAttempts   = 5
+	defaultBackoff    = 2 * time.Second
+	defaultMaxBackoff = time.Minute
+	defaultTimeout    = 10 * time.Second
+)
+
+var Events = []string{EventPollOpened, EventPollClosed, EventPollQuorum, EventPollResults}
+
+// Target is a registered webhook an event should be delivered to
+type Target struct {
+	Id     string
+	URL    string
+	Secret string
+}
+
+// Delivery is the outcome of one attempt to deliver an event to a target
+type Delivery struct {
+	// Shared by every attempt at delivering the same event to the same target
+	Id         string
+	WebhookId  string
+	Event      string
+	Attempt    int
+	StatusCode int
+	Error      string
+	Success    bool
+	Date       time.Time
+}
+
+// Store is where webhooks are registered and deliveries are logged
+type Store interface {
+	// Targets returns the webhooks subscribed to an event
+	Targets(ctx context.Context, event string) ([]Target, error)
+	RecordDelivery(ctx context.Context, delivery Delivery) error
+}
+
+// Payload is the JSON body sent to webhooks
+type Payload struct {
+	Id        string    `json:"id"`
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Data      any       `json:"data"`
+}
+
+type Config struct {
+	Workers   int
+	QueueSize int
+	// Number of times a delivery is attempted before giving up
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout for each delivery attempt
+	Timeout time.Duration
+}
+
+type event struct {
+	name string
+	data any
+	time time.Time
+}
+
+// pendingDelivery is the next attempt at delivering an event to one target
+type pendingDelivery struct {
+	target  Target
+	event   string
+	id      string
+	body    []byte
+	attempt int
+	// How long to wait before the attempt after this one
+	backoff time.Duration
+}
+
+// Dispatcher delivers events to webhooks in the background
+type Dispatcher struct {
+	store      Store
+	config     Config
+	httpClient *http.Client
+	queue      chan event
+	deliveries chan pendingDelivery
+	quit       chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+}
+
+// NewDispatcher creates a dispatcher, zero values in config are replaced with defaults
+func NewDispatcher(store Store, config Config) *Dispatcher {
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Attempts <= 0 {
+		config.Attempts = defaultAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = defaultMaxBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	return &Dispatcher{
+		store:      store,
+		config:     config,
+		httpClient: &http.Client{Timeout: config.Timeout},
+		queue:      make(chan event, config.QueueSize),
+		deliveries: make(chan pendingDelivery, config.QueueSize),
+		quit:       make(chan struct{}),
+	}
+}
+
+// Start launches the workers that deliver events
+func (d *Dispatcher) Start() {
+	for i := 0; i < d.config.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+}
+
+// Stop waits for in flight attempts to finish and abandons any deliveries still waiting, including retries
+func (d *Dispatcher) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.quit)
+	})
+	d.wg.Wait()
+}
+
+// Publish queues an event for delivery, returning false if the queue is full and the event was dropped
+//
+// It never blocks, so callers don't have to wait on other services
+func (d *Dispatcher) Publish(name string, data any) bool {
+	select {
+	case d.queue <- event{name: name, data: data, time: time.Now()}:
+		return true
+	default:
+		logging.Logger.WithFields(logrus.Fields{"module": "webhook", "method": "Publish", "event": name}).Error("webhook queue is full, dropping event")
+		return false
+	}
+}
+
+// Sign computes the signature sent in the X-Vote-Signature header
+//
+// Receivers should compute the HMAC-SHA256 of the raw body with their secret and compare
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature produced by Sign
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.quit:
+			return
+		case ev := <-d.queue:
+			d.dispatch(ev)
+		case pending := <-d.deliveries:
+			d.deliver(context.Background(), pending)
+		}
+	}
+}
+
+// dispatch queues a delivery of an event to each target subscribed to it
+func (d *Dispatcher) dispatch(ev event) {
+	ctx := context.Background()
+	targets, err := d.store.Targets(ctx, ev.name)
+	if err != nil {
+		logging.Logger.WithFields(logrus.Fields{"module": "webhook", "method": "dispatch", "event": ev.name}).Error(err)
+		return
+	}
+	for _, target := range targets {
+		id, err := newDeliveryId()
+		if err != nil {
+			logging.Logger.WithFields(logrus.Fields{"module": "webhook", "method": "dispatch"}).Error(err)
+			return
+		}
+		body, err := json.Marshal(Payload{Id: id, Event: ev.name, Timestamp: ev.time, Data: ev.data})
+		if err != nil {
+			logging.Logger.WithFields(logrus.Fields{"module": "webhook", "method": "dispatch"}).Error(err)
+			return
+		}
+		d.schedule(pendingDelivery{target: target, event: ev.name, id: id, body: body, attempt: 1, backoff: d.config.Backoff}, 0)
+	}
+}
+
+// schedule queues a delivery for the workers after a delay, without blocking the caller
+//
+// Deliveries still waiting when the dispatcher stops are abandoned
+func (d *Dispatcher) schedule(pending pendingDelivery, delay time.Duration) {
+	enqueue := func() {
+		select {
+		case d.deliveries <- pending:
+		case <-d.quit:
+		}
+	}
+	if delay <= 0 {
+		go enqueue()
+		return
+	}
+	time.AfterFunc(delay, enqueue)
+}
+
+// deliver makes one attempt at a delivery, scheduling the next attempt with backoff if it fails
+func (d *Dispatcher) deliver(ctx context.Context, pending pendingDelivery) {
+	delivery := d.attempt(ctx, pending.target, pending.event, pending.id, pending.body)
+	delivery.Attempt = pending.attempt
+	if err := d.store.RecordDelivery(ctx, delivery); err != nil {
+		logging.Logger.WithFields(logrus.Fields{"module": "webhook", "method": "deliver record"}).Error(err)
+	}
+	if delivery.Success || pending.attempt >= d.config.Attempts {
+		return
+	}
+	delay := pending.backoff
+	pending.attempt++
+	pending.backoff = min(pending.backoff*2, d.config.MaxBackoff)
+	d.schedule(pending, delay)
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, target Target, name string, id string, body []byte) Delivery {
+	delivery := Delivery{
+		Id:        id,
+		WebhookId: target.Id,
+		Event:     name,
+		Date:      time.Now(),
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target.URL, bytes.NewReader(body))
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CSH-Vote-Webhook")
+	req.Header.Set(EventHeader, name)
+	req.Header.Set(DeliveryHeader, id)
+	req.Header.Set(SignatureHeader, Sign(target.Secret, body))
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+	resp.Body.Close()
+	delivery.StatusCode = resp.StatusCode
+	delivery.Success = resp.StatusCode >= 200 && resp.StatusCode < 300
+	if !delivery.Success {
+		delivery.Error = fmt.Sprintf("unexpected response %s", resp.Status)
+	}
+	return delivery
+}
+
+func newDeliveryId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
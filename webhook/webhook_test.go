@@ -0,0 +1,174 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryStore struct {
+	targets    []Target
+	mu         sync.Mutex
+	deliveries []Delivery
+	recorded   chan struct{}
+}
+
+func (store *memoryStore) Targets(ctx context.Context, event string) ([]Target, error) {
+	return store.targets, nil
+}
+
+func (store *memoryStore) RecordDelivery(ctx context.Context, delivery Delivery) error {
+	store.mu.Lock()
+	store.deliveries = append(store.deliveries, delivery)
+	store.mu.Unlock()
+	store.recorded <- struct{}{}
+	return nil
+}
+
+func waitForDeliveries(t *testing.T, store *memoryStore, count int) []Delivery {
+	for i := 0; i < count; i++ {
+		select {
+		case <-store.recorded:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d deliveries were recorded", i, count)
+		}
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.deliveries
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"event":"poll.opened"}`)
+	signature := Sign("secret", body)
+	assert.Equal(t, "sha256=", signature[:7])
+	assert.True(t, Verify("secret", body, signature))
+	assert.False(t, Verify("other", body, signature))
+	assert.False(t, Verify("secret", []byte(`{"event":"poll.closed"}`), signature))
+}
+
+func TestDeliver(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !Verify("secret", body, r.Header.Get(SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var payload Payload
+		json.Unmarshal(body, &payload)
+		if payload.Event != r.Header.Get(EventHeader) || payload.Id != r.Header.Get(DeliveryHeader) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received.Add(1)
+	}))
+	defer server.Close()
+
+	store := &memoryStore{targets: []Target{{Id: "hook", URL: server.URL, Secret: "secret"}}, recorded: make(chan struct{}, 10)}
+	dispatcher := NewDispatcher(store, Config{Workers: 1})
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	assert.True(t, dispatcher.Publish(EventPollOpened, map[string]string{"id": "1"}))
+	deliveries := waitForDeliveries(t, store, 1)
+	assert.Equal(t, int32(1), received.Load())
+	assert.True(t, deliveries[0].Success)
+	assert.Equal(t, "hook", deliveries[0].WebhookId)
+	assert.Equal(t, EventPollOpened, deliveries[0].Event)
+	assert.Equal(t, 1, deliveries[0].Attempt)
+	assert.Equal(t, http.StatusOK, deliveries[0].StatusCode)
+}
+
+func TestDeliverRetries(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	store := &memoryStore{targets: []Target{{Id: "hook", URL: server.URL, Secret: "secret"}}, recorded: make(chan struct{}, 10)}
+	dispatcher := NewDispatcher(store, Config{Workers: 1, Attempts: 4, Backoff: time.Millisecond})
+	dispatcher.Start()
+	defer dispatcher.Stop()
+
+	dispatcher.Publish(EventPollClosed, nil)
+	deliveries := waitForDeliveries(t, store, 3)
+	assert.Equal(t, []int{1, 2, 3}, []int{deliveries[0].Attempt, deliveries[1].Attempt, deliveries[2].Attempt})
+	assert.False(t, deliveries[0].Success)
+	assert.Equal(t, http.StatusInternalServerError, deliveries[0].StatusCode)
+	assert.NotEmpty(t, deliveries[0].Error)
+	assert.True(t, deliveries[2].Success)
+	// every attempt at the same delivery shares an id, so receivers can ignore duplicates
+	assert.Equal(t, deliveries[0].Id, deliveries[2].Id)
+}
+
+func TestDeliverGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	store := &memoryStore{targets: []Target{{Id: "hook", URL: server.URL, Secret: "secret"}}, recorded: make(chan struct{}, 10)}
+	dispatcher := NewDispatcher(store, Config{Workers: 1, Attempts: 2, Backoff: time.Millisecond})
+	dispatcher.Start()
+
+	dispatcher.Publish(EventPollResults, nil)
+	deliveries := waitForDeliveries(t, store, 2)
+	dispatcher.Stop()
+	assert.Len(t, deliveries, 2)
+	assert.False(t, deliveries[1].Success)
+}
+
+func TestDeadWebhookDoesNotDelayOthers(t *testing.T) {
+	dead := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer dead.Close()
+	alive := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer alive.Close()
+
+	store := &memoryStore{
+		targets:  []Target{{Id: "dead", URL: dead.URL, Secret: "secret"}, {Id: "alive", URL: alive.URL, Secret: "secret"}},
+		recorded: make(chan struct{}, 10),
+	}
+	// the retry is an hour away, so the only worker has to deliver to alive while it waits
+	dispatcher := NewDispatcher(store, Config{Workers: 1, Attempts: 2, Backoff: time.Hour})
+	dispatcher.Start()
+
+	dispatcher.Publish(EventPollOpened, nil)
+	deliveries := waitForDeliveries(t, store, 2)
+	dispatcher.Stop()
+	success := map[string]bool{}
+	for _, delivery := range deliveries {
+		success[delivery.WebhookId] = delivery.Success
+	}
+	assert.Equal(t, map[string]bool{"dead": false, "alive": true}, success)
+}
+
+func TestPublishDoesNotBlock(t *testing.T) {
+	// without any workers nothing drains the queue
+	dispatcher := NewDispatcher(&memoryStore{}, Config{QueueSize: 1})
+	assert.True(t, dispatcher.Publish(EventPollOpened, nil))
+
+	done := make(chan bool)
+	go func() {
+		done <- dispatcher.Publish(EventPollOpened, nil)
+	}()
+	select {
+	case queued := <-done:
+		assert.False(t, queued)
+	case <-time.After(time.Second):
+		t.Fatal("Publish blocked on a full queue")
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	cshAuth "github.com/computersciencehouse/csh-auth"
+	"github.com/computersciencehouse/vote/database"
+	"github.com/computersciencehouse/vote/logging"
+	"github.com/computersciencehouse/vote/webhook"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How many delivery attempts are shown on the webhooks page
+const recentDeliveries = 50
+
+var webhooks *webhook.Dispatcher
+
+// webhookStore lets the dispatcher find webhooks and log deliveries in the database
+type webhookStore struct{}
+
+func (webhookStore) Targets(ctx context.Context, event string) ([]webhook.Target, error) {
+	hooks, err := database.GetWebhooksForEvent(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+	targets := make([]webhook.Target, 0, len(hooks))
+	for _, hook := range hooks {
+		targets = append(targets, webhook.Target{Id: hook.Id, URL: hook.URL, Secret: hook.Secret})
+	}
+	return targets, nil
+}
+
+func (webhookStore) RecordDelivery(ctx context.Context, delivery webhook.Delivery) error {
+	return database.WriteWebhookDelivery(ctx, &database.WebhookDelivery{
+		WebhookId:  delivery.WebhookId,
+		DeliveryId: delivery.Id,
+		Event:      delivery.Event,
+		Attempt:    delivery.Attempt,
+		StatusCode: delivery.StatusCode,
+		Error:      delivery.Error,
+		Success:    delivery.Success,
+		Date:       delivery.Date,
+	})
+}
+
+// emitWebhook Queues an event for any webhooks subscribed to it, without waiting for them
+func emitWebhook(event string, data any) {
+	if webhooks == nil {
+		return
+	}
+	webhooks.Publish(event, data)
+}
+
+// emitPollClosed Tells webhooks that a poll closed and what its results were
+func emitPollClosed(ctx context.Context, poll *database.Poll) {
+	emitWebhook(webhook.EventPollClosed, toAPIPoll(poll))
+	results, err := poll.GetOfficialResult(ctx)
+	if err != nil {
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "emitPollClosed"}).Error(err)
+		return
+	}
+	emitWebhook(webhook.EventPollResults, toAPIResults(poll, results))
+}
+
+// quorumTurnout is sent instead of the results when a poll hiding its results reaches quorum
+type quorumTurnout struct {
+	PollId               string `json:"pollId"`
+	NumVotes             int    `json:"numVotes"`
+	VotesNeededForQuorum int    `json:"votesNeededForQuorum"`
+}
+
+// quorumPayload Returns what webhooks are sent when a poll reaches quorum, only the turnout if its results are hidden
+func quorumPayload(poll *database.Poll, results APIResults) any {
+	if Can(cshAuth.CSHUserInfo{}, ActionViewResults, poll) {
+		return results
+	}
+	return quorumTurnout{
+		PollId:               results.PollId,
+		NumVotes:             results.NumVotes,
+		VotesNeededForQuorum: results.VotesNeededForQuorum,
+	}
+}
+
+// emitQuorumReached Tells webhooks when a gatekeep poll reaches quorum
+//
+// Ballots can be cast at the same time on any instance, so the poll is marked as notified
+// and only whoever marks it first sends the webhook
+func emitQuorumReached(ctx context.Context, poll *database.Poll) {
+	if !poll.Gatekeep || poll.QuorumNotified {
+		return
+	}
+	results, err := poll.GetResult(ctx)
+	if err != nil {
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "emitQuorumReached"}).Error(err)
+		return
+	}
+	apiResults := toAPIResults(poll, results)
+	if apiResults.NumVotes < apiResults.VotesNeededForQuorum {
+		return
+	}
+	first, err := poll.MarkQuorumNotified(ctx)
+	if err != nil {
+		logging.Logger.WithContext(ctx).WithFields(logrus.Fields{"method": "emitQuorumReached"}).Error(err)
+		return
+	}
+	if first {
+		emitWebhook(webhook.EventPollQuorum, quorumPayload(poll, apiResults))
+	}
+}
+
+// validWebhookURL Checks a webhook URL is somewhere we can POST to
+func validWebhookURL(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// GetWebhooks Displays the webhooks and their recent deliveries for RTPs to manage
+func GetWebhooks(c *gin.Context) {
+	renderWebhooks(c, http.StatusOK, "", "")
+}
+
+// CreateWebhook Registers a new webhook, showing its signing secret to the RTP this one time
+func CreateWebhook(c *gin.Context) {
+	user := GetUserData(c)
+
+	hookURL := strings.TrimSpace(c.PostForm("url"))
+	if !validWebhookURL(hookURL) {
+		renderWebhooks(c, http.StatusBadRequest, "", "The URL needs to be an http or https URL")
+		return
+	}
+	events := c.PostFormArray("events")
+	if len(events) == 0 {
+		renderWebhooks(c, http.StatusBadRequest, "", "You need to pick at least one event")
+		return
+	}
+	for _, event := range events {
+		if !slices.Contains(webhook.Events, event) {
+			renderWebhooks(c, http.StatusBadRequest, "", "Unknown event: "+event)
+			return
+		}
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	hook := database.Webhook{
+		Id:        "",
+		URL:       hookURL,
+		Secret:    secret,
+		Events:    events,
+		CreatedBy: user.Username,
+		CreatedAt: time.Now(),
+	}
+	_, err = database.CreateWebhook(c, &hook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	action := database.Action{
+		Id:     "",
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: fmt.Sprintf("Create Webhook %s (events: %s)", hookURL, strings.Join(events, ", ")),
+	}
+	err = database.WriteAction(c, &action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	renderWebhooks(c, http.StatusCreated, secret, "")
+}
+
+// DeleteWebhook Stops sending events to a webhook
+func DeleteWebhook(c *gin.Context) {
+	user := GetUserData(c)
+
+	hook, err := database.DeleteWebhook(c, c.Param("id"))
+	if errors.Is(err, database.ErrWebhookNotFound) {
+		c.Redirect(http.StatusFound, "/webhooks")
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	action := database.Action{
+		Id:     "",
+		Date:   primitive.NewDateTimeFromTime(time.Now()),
+		User:   user.Username,
+		Action: "Delete Webhook " + hook.URL,
+	}
+	err = database.WriteAction(c, &action)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Redirect(http.StatusFound, "/webhooks")
+}
+
+func renderWebhooks(c *gin.Context, status int, newSecret string, formError string) {
+	user := GetUserData(c)
+
+	hooks, err := database.GetWebhooks(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	deliveries, err := database.GetWebhookDeliveries(c, recentDeliveries)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	urls := make(map[string]string, len(hooks))
+	for _, hook := range hooks {
+		urls[hook.Id] = hook.URL
+	}
+
+	render(c, status, "webhooks.tmpl", gin.H{
+		"Webhooks":   hooks,
+		"Deliveries": deliveries,
+		"URLs":       urls,
+		"Events":     webhook.Events,
+		"NewSecret":  newSecret,
+		"FormError":  formError,
+		"Username":   user.Username,
+		"FullName":   user.FullName,
+		"EBoard":     IsEboard(user),
+		"Evals":      IsEvals(user),
+	})
+}
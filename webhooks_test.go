@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/computersciencehouse/vote/database"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuorumPayload(t *testing.T) {
+	results := APIResults{PollId: "poll", VoteType: database.POLL_TYPE_SIMPLE, Open: true, NumVotes: 5, EligibleVoters: 10, VotesNeededForQuorum: 5, Rounds: []map[string]int{{"Yes": 3, "No": 2}}}
+
+	tests := []struct {
+		name    string
+		poll    *database.Poll
+		payload string
+	}{
+		{
+			name:    "results visible",
+			poll:    &database.Poll{Id: "poll", Open: true, Gatekeep: true},
+			payload: `{"pollId": "poll", "voteType": "simple", "open": true, "numVotes": 5, "eligibleVoters": 10, "votesNeededForQuorum": 5, "rounds": [{"Yes": 3, "No": 2}]}`,
+		},
+		{
+			name:    "results hidden",
+			poll:    &database.Poll{Id: "poll", Open: true, Gatekeep: true, Hidden: true},
+			payload: `{"pollId": "poll", "numVotes": 5, "votesNeededForQuorum": 5}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			payload, err := json.Marshal(quorumPayload(test.poll, results))
+			assert.NoError(t, err)
+			assert.JSONEq(t, test.payload, string(payload))
+		})
+	}
+}